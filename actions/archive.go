@@ -0,0 +1,365 @@
+package actions
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+	"webup/pliz/utils"
+)
+
+const (
+	checksumsFilename = "checksums.json" // last entry of the archives (since the version 2 of the manifest)
+	partSize          = 64 * 1024 * 1024 // max size of a part of a streamed entry (kept in memory)
+	partOfRecord      = "PLIZ.part_of"   // PAX record of the parts following the first one of a streamed entry
+)
+
+// archiveWriter writes the entries of a backup into a gzipped tarball, computing their checksums
+type archiveWriter struct {
+	gzip      *gzip.Writer
+	tar       *tar.Writer
+	checksums []manifestFile
+	partSize  int // size of the parts of the streamed entries (partSize, smaller in the tests)
+}
+
+func newArchiveWriter(out io.Writer) *archiveWriter {
+	gzipWriter := gzip.NewWriter(out)
+	return &archiveWriter{gzip: gzipWriter, tar: tar.NewWriter(gzipWriter), checksums: []manifestFile{}, partSize: partSize}
+}
+
+// addContent adds an entry without checksum (i.e. the manifest)
+func (w *archiveWriter) addContent(name string, content []byte) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := w.tar.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := w.tar.Write(content)
+	return err
+}
+
+// addFile copies the file into the entry (a symlink is followed)
+func (w *archiveWriter) addFile(name string, file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+
+	reader, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if err := w.tar.WriteHeader(header); err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	if _, err := io.CopyN(w.tar, io.TeeReader(reader, hash), info.Size()); err != nil {
+		return fmt.Errorf("Unable to archive %s (modified during the backup?): %v", file, err)
+	}
+
+	w.checksums = append(w.checksums, manifestFile{Path: name, Size: info.Size(), SHA256: hex.EncodeToString(hash.Sum(nil))})
+	return nil
+}
+
+// addStream copies the content of the reader (i.e. the output of a dump) into the entry.
+// The size of an entry must be known before its content, so the stream is split into parts of partSize:
+// the first part is the entry, the following ones are '<name>.partN' entries (joined by readBackup).
+func (w *archiveWriter) addStream(name string, reader io.Reader) error {
+	hash := sha256.New()
+	var size int64
+
+	buffer := make([]byte, w.partSize)
+	for part := 0; ; part++ {
+		n, err := io.ReadFull(reader, buffer)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := err != nil
+		if n == 0 && part > 0 {
+			break
+		}
+
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(n), ModTime: time.Now(), Typeflag: tar.TypeReg}
+		if part > 0 {
+			header.Name = fmt.Sprintf("%s.part%d", name, part)
+			header.PAXRecords = map[string]string{partOfRecord: name}
+		}
+		if err := w.tar.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := w.tar.Write(buffer[:n]); err != nil {
+			return err
+		}
+		hash.Write(buffer[:n])
+		size += int64(n)
+
+		if last {
+			break
+		}
+	}
+
+	w.checksums = append(w.checksums, manifestFile{Path: name, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))})
+	return nil
+}
+
+// close writes the checksums of the entries as the last entry, then flushes the archive
+func (w *archiveWriter) close() error {
+	content, err := json.MarshalIndent(w.checksums, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := w.addContent(checksumsFilename, content); err != nil {
+		return err
+	}
+
+	if err := w.tar.Close(); err != nil {
+		return err
+	}
+	return w.gzip.Close()
+}
+
+// openBackup opens the backup archive, decrypted on the fly if it's a .enc file (see decryptBackup)
+func openBackup(file string, key *string, identity *string) (io.ReadCloser, error) {
+	infile, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := decryptBackup(infile, file, key, identity)
+	if err != nil {
+		infile.Close()
+		return nil, err
+	}
+	return &backupFile{ReadCloser: reader, file: infile}, nil
+}
+
+// backupFile is the content of an opened backup file
+type backupFile struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (f *backupFile) Close() error {
+	f.ReadCloser.Close()
+	return f.file.Close()
+}
+
+// decryptBackup returns the archive read from in (the start of the backup file), decrypted on the fly if it's a .enc file:
+// with the password (key) or the private keys of the identity file, depending on its encryption format
+func decryptBackup(in io.Reader, file string, key *string, identity *string) (io.ReadCloser, error) {
+	if !isEncryptedBackup(file) {
+		return ioutil.NopCloser(in), nil
+	}
+
+	// the version of the encryption format is the first byte of the file
+	buffered := bufio.NewReader(in)
+	version, err := buffered.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the backup %s: %v", file, err)
+	}
+
+	switch version[0] {
+	case utils.V1, utils.V2:
+		if key == nil || *key == "" {
+			return nil, fmt.Errorf("The backup %s is encrypted with a password (set it with -k)", file)
+		}
+		return openDecryptedStream(buffered, func(in io.Reader, out io.Writer) error {
+			return utils.Decrypt(in, out, []byte(*key))
+		}), nil
+	case utils.V3:
+		if identity == nil || *identity == "" {
+			return nil, fmt.Errorf("The backup %s is encrypted with public keys (set the file of your private key with --identity)", file)
+		}
+		identities, err := utils.ReadIdentityFile(*identity)
+		if err != nil {
+			return nil, err
+		}
+		return openDecryptedStream(buffered, func(in io.Reader, out io.Writer) error {
+			err := utils.DecryptWithIdentities(in, out, identities)
+			if errors.Is(err, utils.ErrNoIdentity) {
+				return fmt.Errorf("The backup %s is not encrypted for the keys of %s: %w", file, *identity, err)
+			}
+			return err
+		}), nil
+	}
+
+	return nil, fmt.Errorf("Unknown encryption format of the backup %s (version %d)", file, version[0])
+}
+
+func isEncryptedBackup(file string) bool {
+	return strings.HasSuffix(file, ".enc")
+}
+
+// openDecryptedStream decrypts the input on the fly, without writing the decrypted content to the disk.
+// The stream is only complete (and authenticated with V1) when it has been read until the end.
+func openDecryptedStream(in io.Reader, decrypt func(in io.Reader, out io.Writer) error) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(decrypt(in, writer))
+	}()
+
+	return reader
+}
+
+// readBackupError explains an error of readBackup (an invalid archive is often due to a wrong key)
+func readBackupError(file string, err error) error {
+	if _, ok := err.(*checksumError); ok || errors.Is(err, utils.ErrNoIdentity) {
+		return err
+	}
+	if isEncryptedBackup(file) {
+		return fmt.Errorf("Unable to read the backup %s (wrong key or corrupted file): %v", file, err)
+	}
+	return fmt.Errorf("Unable to read the backup %s: %v", file, err)
+}
+
+// checksumError lists the entries of the archive which don't match its manifest
+type checksumError struct {
+	Problems []string
+}
+
+func (e *checksumError) Error() string {
+	return fmt.Sprintf("The backup is corrupted:\n%s", strings.Join(e.Problems, "\n"))
+}
+
+// readBackup calls handle for each entry of the archive (the manifest is read first, nil for the old archives).
+// The parts of a streamed entry are read as a single entry, whose size is set in the header once it's read.
+// The checksums of the entries are compared with the manifest at the end: a *checksumError is returned if they don't match.
+func readBackup(reader io.Reader, handle func(header *tar.Header, manifest *backupManifest, entry io.Reader) error) (*backupManifest, error) {
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+
+	var manifest *backupManifest
+	var next *tar.Header // read after the parts of an entry
+	end := false
+	checksums := map[string]string{}
+	checksumsRead := false
+	for !end {
+		header := next
+		next = nil
+		if header == nil {
+			header, err = tarReader.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return manifest, err
+			}
+		}
+
+		if header.Name == manifestFilename && manifest == nil {
+			manifest, err = readManifest(tarReader)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if header.Name == checksumsFilename && manifest != nil && manifest.Version >= 2 {
+			if err := json.NewDecoder(tarReader).Decode(&manifest.Files); err != nil {
+				return manifest, fmt.Errorf("Unable to read the checksums of the backup: %v", err)
+			}
+			checksumsRead = true
+			continue
+		}
+
+		if header.FileInfo().IsDir() {
+			if err := handle(header, manifest, tarReader); err != nil {
+				return manifest, err
+			}
+			continue
+		}
+
+		entry := &entryReader{tar: tarReader, name: header.Name, hash: sha256.New()}
+		if err := handle(header, manifest, entry); err != nil {
+			return manifest, err
+		}
+		// the entry may have not been read by handle
+		if _, err := io.Copy(ioutil.Discard, entry); err != nil {
+			return manifest, err
+		}
+
+		header.Size = entry.size
+		checksums[header.Name] = hex.EncodeToString(entry.hash.Sum(nil))
+		next = entry.next
+		end = entry.end
+	}
+
+	if manifest != nil {
+		problems := manifest.checksumProblems(checksums)
+		// the checksums are the last entry of the archive: it may have been truncated
+		if manifest.Version >= 2 && !checksumsRead {
+			problems = append([]string{fmt.Sprintf("%s is missing", checksumsFilename)}, problems...)
+		}
+		if len(problems) > 0 {
+			return manifest, &checksumError{Problems: problems}
+		}
+	}
+
+	return manifest, nil
+}
+
+// entryReader reads an entry of the archive with its following parts, computing its checksum
+type entryReader struct {
+	tar  *tar.Reader
+	name string
+	hash hash.Hash
+	size int64
+
+	done bool
+	next *tar.Header // the header following the last part (nil at the end of the archive)
+	end  bool        // the end of the archive has been reached
+}
+
+func (r *entryReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+
+	for {
+		n, err := r.tar.Read(p)
+		r.hash.Write(p[:n])
+		r.size += int64(n)
+		if err != io.EOF {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+
+		// end of a part: the next entry may be the following part
+		header, err := r.tar.Next()
+		if err == io.EOF {
+			r.done = true
+			r.end = true
+			return 0, io.EOF
+		} else if err != nil {
+			return 0, err
+		}
+		if header.PAXRecords[partOfRecord] != r.name {
+			r.done = true
+			r.next = header
+			return 0, io.EOF
+		}
+	}
+}
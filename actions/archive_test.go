@@ -0,0 +1,355 @@
+package actions
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// archiveEntry is an entry read by readBackup
+type archiveEntry struct {
+	Name    string
+	Content string
+}
+
+// writeTestArchive writes an archive (version 2 of the manifest) with the file and the stream
+func writeTestArchive(t *testing.T, write func(archive *archiveWriter)) []byte {
+	t.Helper()
+
+	manifest, err := json.Marshal(backupManifest{Version: manifestVersion, Databases: []manifestDatabase{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	archive := newArchiveWriter(&buffer)
+	if err := archive.addContent(manifestFilename, manifest); err != nil {
+		t.Fatal(err)
+	}
+	write(archive)
+	if err := archive.close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// readTestArchive reads all the entries of the archive
+func readTestArchive(data []byte) (*backupManifest, []archiveEntry, error) {
+	entries := []archiveEntry{}
+	manifest, err := readBackup(bytes.NewReader(data), func(header *tar.Header, manifest *backupManifest, entry io.Reader) error {
+		content, err := ioutil.ReadAll(entry)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, archiveEntry{Name: header.Name, Content: string(content)})
+		return nil
+	})
+	return manifest, entries, err
+}
+
+func testFile(t *testing.T, content string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestReadBackup(t *testing.T) {
+	file := testFile(t, "APP_KEY=secret\n")
+	data := writeTestArchive(t, func(archive *archiveWriter) {
+		if err := archive.addFile("config/.env", file); err != nil {
+			t.Fatal(err)
+		}
+		if err := archive.addStream("databases/db/app.sql", strings.NewReader("CREATE TABLE users;\n")); err != nil {
+			t.Fatal(err)
+		}
+		if err := archive.addStream("databases/db/empty.sql", strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	manifest, entries, err := readTestArchive(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []archiveEntry{
+		{Name: "config/.env", Content: "APP_KEY=secret\n"},
+		{Name: "databases/db/app.sql", Content: "CREATE TABLE users;\n"},
+		{Name: "databases/db/empty.sql", Content: ""},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("unexpected entries:\n%v\nexpected:\n%v", entries, expected)
+	}
+	if manifest == nil || len(manifest.Files) != 3 || manifest.file("databases/db/app.sql").Size != 20 {
+		t.Errorf("unexpected checksums: %+v", manifest)
+	}
+}
+
+func TestReadBackupChecksumProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		write    func(t *testing.T, archive *archiveWriter)
+		problems []string
+	}{
+		{
+			name: "invalid checksum",
+			write: func(t *testing.T, archive *archiveWriter) {
+				archive.addStream("databases/db/app.sql", strings.NewReader("dump"))
+				archive.checksums[0].SHA256 = strings.Repeat("0", 64)
+			},
+			problems: []string{"Invalid checksum for databases/db/app.sql"},
+		},
+		{
+			name: "missing entry",
+			write: func(t *testing.T, archive *archiveWriter) {
+				archive.addStream("databases/db/app.sql", strings.NewReader("dump"))
+				archive.checksums = append(archive.checksums, manifestFile{Path: "files/storage/a", SHA256: strings.Repeat("0", 64)})
+			},
+			problems: []string{"files/storage/a is missing"},
+		},
+		{
+			name: "entry without checksum",
+			write: func(t *testing.T, archive *archiveWriter) {
+				archive.addStream("databases/db/app.sql", strings.NewReader("dump"))
+				archive.addContent("files/added", []byte("added"))
+			},
+			problems: []string{"files/added is not described in the manifest of the backup"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := writeTestArchive(t, func(archive *archiveWriter) {
+				test.write(t, archive)
+			})
+
+			_, _, err := readTestArchive(data)
+			checksumErr, ok := err.(*checksumError)
+			if !ok {
+				t.Fatalf("expected a checksum error, got %v", err)
+			}
+			if !reflect.DeepEqual(checksumErr.Problems, test.problems) {
+				t.Errorf("expected the problems %q, got %q", test.problems, checksumErr.Problems)
+			}
+		})
+	}
+}
+
+func TestReadBackupTruncated(t *testing.T) {
+	data := writeTestArchive(t, func(archive *archiveWriter) {
+		archive.addStream("databases/db/app.sql", strings.NewReader(strings.Repeat("INSERT INTO users;\n", 1000)))
+	})
+
+	_, _, err := readTestArchive(data[:len(data)/2])
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, ok := err.(*checksumError); ok {
+		t.Errorf("expected a read error, got %v", err)
+	}
+}
+
+func TestReadBackupWithoutManifest(t *testing.T) {
+	// archive made by the older versions of pliz
+	var buffer bytes.Buffer
+	archive := newArchiveWriter(&buffer)
+	archive.addContent("config/.env", []byte("APP_KEY=secret\n"))
+	archive.tar.Close()
+	archive.gzip.Close()
+
+	manifest, entries, err := readTestArchive(buffer.Bytes())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if manifest != nil || len(entries) != 1 || entries[0].Content != "APP_KEY=secret\n" {
+		t.Errorf("unexpected content: %v %v", manifest, entries)
+	}
+}
+
+// tarNames lists the names of the entries of the archive
+func tarNames(t *testing.T, data []byte) []string {
+	t.Helper()
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+
+	names := []string{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return names
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+}
+
+// withoutEntry rewrites the archive without the entry
+func withoutEntry(t *testing.T, data []byte, name string) []byte {
+	t.Helper()
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if header.Name == name {
+			continue
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(tarWriter, tarReader); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tarWriter.Close()
+	gzipWriter.Close()
+	return buffer.Bytes()
+}
+
+func TestAddStreamParts(t *testing.T) {
+	const size = 10
+
+	tests := []struct {
+		name   string
+		length int
+		parts  []string
+	}{
+		{name: "empty", length: 0, parts: []string{"db.sql"}},
+		{name: "smaller than a part", length: size - 1, parts: []string{"db.sql"}},
+		{name: "one part", length: size, parts: []string{"db.sql"}},
+		{name: "one part and one byte", length: size + 1, parts: []string{"db.sql", "db.sql.part1"}},
+		{name: "several parts", length: 3*size + 5, parts: []string{"db.sql", "db.sql.part1", "db.sql.part2", "db.sql.part3"}},
+		{name: "exact parts", length: 3 * size, parts: []string{"db.sql", "db.sql.part1", "db.sql.part2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := strings.Repeat("0123456789abcdef", test.length)[:test.length]
+			data := writeTestArchive(t, func(archive *archiveWriter) {
+				archive.partSize = size
+				if err := archive.addStream("db.sql", strings.NewReader(content)); err != nil {
+					t.Fatal(err)
+				}
+				// the entry following the parts
+				if err := archive.addStream("next.sql", strings.NewReader("next")); err != nil {
+					t.Fatal(err)
+				}
+			})
+
+			names := tarNames(t, data)
+			expectedNames := append(append([]string{manifestFilename}, test.parts...), "next.sql", checksumsFilename)
+			if !reflect.DeepEqual(names, expectedNames) {
+				t.Errorf("expected the entries %q, got %q", expectedNames, names)
+			}
+
+			manifest, entries, err := readTestArchive(data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected := []archiveEntry{{Name: "db.sql", Content: content}, {Name: "next.sql", Content: "next"}}
+			if !reflect.DeepEqual(entries, expected) {
+				t.Errorf("unexpected entries:\n%v\nexpected:\n%v", entries, expected)
+			}
+			if file := manifest.file("db.sql"); file == nil || file.Size != int64(test.length) {
+				t.Errorf("unexpected checksum of the entry: %+v", file)
+			}
+		})
+	}
+}
+
+func TestReadBackupPartiallyReadEntry(t *testing.T) {
+	content := strings.Repeat("x", 25)
+	data := writeTestArchive(t, func(archive *archiveWriter) {
+		archive.partSize = 10
+		archive.addStream("db.sql", strings.NewReader(content))
+		archive.addStream("next.sql", strings.NewReader("next"))
+	})
+
+	headers := []*tar.Header{}
+	_, err := readBackup(bytes.NewReader(data), func(header *tar.Header, manifest *backupManifest, entry io.Reader) error {
+		headers = append(headers, header)
+		// the rest of the entry is read by readBackup
+		_, err := entry.Read(make([]byte, 1))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the size is set once the entry has been read
+	sizes := map[string]int64{}
+	for _, header := range headers {
+		sizes[header.Name] = header.Size
+	}
+
+	expected := map[string]int64{"db.sql": 25, "next.sql": 4}
+	if !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("expected the sizes %v, got %v", expected, sizes)
+	}
+}
+
+func TestReadBackupMissingPart(t *testing.T) {
+	data := writeTestArchive(t, func(archive *archiveWriter) {
+		archive.partSize = 10
+		archive.addStream("db.sql", strings.NewReader(strings.Repeat("x", 25)))
+	})
+
+	_, _, err := readTestArchive(withoutEntry(t, data, "db.sql.part1"))
+	checksumErr, ok := err.(*checksumError)
+	if !ok {
+		t.Fatalf("expected a checksum error, got %v", err)
+	}
+	expected := []string{"Invalid checksum for db.sql"}
+	if !reflect.DeepEqual(checksumErr.Problems, expected) {
+		t.Errorf("expected the problems %q, got %q", expected, checksumErr.Problems)
+	}
+}
+
+func TestEntryReaderDone(t *testing.T) {
+	data := writeTestArchive(t, func(archive *archiveWriter) {
+		archive.partSize = 10
+		archive.addStream("db.sql", strings.NewReader(strings.Repeat("x", 15)))
+	})
+
+	_, err := readBackup(bytes.NewReader(data), func(header *tar.Header, manifest *backupManifest, entry io.Reader) error {
+		if _, err := ioutil.ReadAll(entry); err != nil {
+			return err
+		}
+		// the following entries are not read by the reader of the entry
+		if n, err := entry.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+			t.Errorf("expected EOF once the entry has been read, got %d, %v", n, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
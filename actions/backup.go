@@ -1,12 +1,13 @@
 package actions
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
-	"strings"
 	"time"
 	"webup/pliz/config"
 	"webup/pliz/domain"
@@ -14,7 +15,6 @@ import (
 
 	"github.com/Songmu/prompter"
 	"github.com/fatih/color"
-	"github.com/jhoonb/archivex"
 )
 
 func BackupActionHandler(ctx domain.ExecutionContext, backupFilesOpt *bool, backupDBOpt *bool, outputOpt *string, key *string, verbose bool) error {
@@ -35,104 +35,61 @@ func BackupActionHandler(ctx domain.ExecutionContext, backupFilesOpt *bool, back
 
 	fmt.Println("")
 
-	// prepare the directory to store the backup
-	backupDir := ".pliz_backup"
-	err := os.Mkdir(backupDir, os.ModePerm)
+	dryRun := domain.IsDryRun()
+
+	encrypt, encryption, err := backupEncryption(key)
 	if err != nil {
-		return fmt.Errorf("Unable to create a backup directory: %s\n", err)
-	}
-	defer os.RemoveAll(backupDir)
-
-	// config files backup
-	if len(config.Get().ConfigFiles) > 0 {
-		dir := path.Join(backupDir, "backup", "config")
-		os.MkdirAll(dir, 0755)
-		for _, configFile := range config.Get().ConfigFiles {
-			if _, err = os.Stat(configFile.Target); err == nil {
-				target := path.Join(dir, configFile.Target)
-				os.MkdirAll(filepath.Dir(target), os.ModePerm)
-				os.Link(configFile.Target, target)
-			} else {
-				return fmt.Errorf("Unable to backup a config file: %s\n", err)
-			}
-		}
+		return err
 	}
+	encrypted := encrypt != nil
 
+	// the databases are described in the manifest, which is the first entry of the archive
+	manifest := newBackupManifest(ctx)
+	dumps := []backupDump{}
 	if backupDB {
-		// databases dump
 		for _, dbBackup := range config.Get().BackupConfig.Databases {
-			dir := path.Join(backupDir, "backup", "databases", dbBackup.Container)
-			err := os.MkdirAll(dir, 0755)
-			if err != nil {
-				return fmt.Errorf("Unable to create the db backup directory: %s\n", err)
-			}
-			err = makeDump(ctx, dbBackup, dir, verbose)
+			dbManifest, dbDumps, err := prepareDumps(ctx, dbBackup, verbose)
 			if err != nil {
 				return fmt.Errorf("Unable to backup databases: %s\n", err)
 			}
+			manifest.Databases = append(manifest.Databases, dbManifest)
+			dumps = append(dumps, dbDumps...)
 		}
 	}
 
-	if backupFiles {
-		// files
-		filesDir := path.Join(backupDir, "backup", "files")
-		os.MkdirAll(filesDir, os.ModePerm)
+	// config files and files to copy into the archive
+	configEntries := []backupEntry{}
+	for _, configFile := range config.Get().ConfigFiles {
+		if _, err := os.Stat(configFile.Target); err != nil {
+			return fmt.Errorf("Unable to backup a config file: %s\n", err)
+		}
+		configEntries = append(configEntries, backupEntry{Name: path.Join("config", filepath.ToSlash(configFile.Target)), File: configFile.Target})
+	}
 
-		// prepare a walk function to handle the whole hierarchy
-		walkFunc := func(filepath string, info os.FileInfo, err error) error {
+	fileEntries := []backupEntry{}
+	if backupFiles {
+		walkFunc := func(file string, info os.FileInfo, err error) error {
 			if err != nil {
-				return fmt.Errorf("%s file or directory not found \n%s\n", filepath, err)
+				return fmt.Errorf("%s file or directory not found \n%s\n", file, err)
 			}
-			target := path.Join(filesDir, filepath)
-
-			// just create the directory
 			if info.IsDir() {
-				err := os.MkdirAll(target, os.ModePerm)
+				return nil
+			}
+			// symlinks are followed, the other special files are ignored
+			if info, err = os.Stat(file); err != nil || !info.Mode().IsRegular() {
 				return err
 			}
-
-			// perform a hard link
-			linkErr := os.Link(filepath, target)
-			return linkErr
+			fileEntries = append(fileEntries, backupEntry{Name: path.Join("files", filepath.ToSlash(file)), File: file})
+			return nil
 		}
 
 		for _, file := range config.Get().BackupConfig.Files {
-			err = filepath.Walk(file, walkFunc)
-			if err != nil {
+			if err := filepath.Walk(file, walkFunc); err != nil {
 				return fmt.Errorf("Unable to walk into %s\n%s\n", file, err)
 			}
 		}
 	}
 
-	tmpArchiveFilename := path.Join(backupDir, "backup_archive.tar.gz")
-
-	tar := new(archivex.TarFile)
-	tar.Create(tmpArchiveFilename)
-	tar.AddAll(path.Join(backupDir, "backup"), false)
-	tar.Close()
-
-	if key != nil && *key != "" {
-		tmpEncryptedFilename := path.Join(backupDir, "backup_archive.tar.gz.enc")
-		infile, err := os.Open(tmpArchiveFilename)
-		if err != nil {
-			return fmt.Errorf("Unable to open the archive: %s\n", err)
-		}
-
-		outfile, err := os.OpenFile(tmpEncryptedFilename, os.O_RDWR|os.O_CREATE, 0644)
-		if err != nil {
-			return fmt.Errorf("Unable to create the encrypted file: %s\n", err)
-		}
-
-		err = utils.Encrypt(infile, outfile, []byte(*key))
-		if err != nil {
-			return fmt.Errorf("Unable to encrypt file: %s\n", err)
-		}
-		infile.Close()
-		outfile.Close()
-
-		tmpArchiveFilename = tmpEncryptedFilename
-	}
-
 	// save the archive with the right name
 	archiveFilename := ""
 	if outputOpt != nil && *outputOpt != "" {
@@ -142,187 +99,245 @@ func BackupActionHandler(ctx domain.ExecutionContext, backupFilesOpt *bool, back
 		year, month, day := now.Date()
 		hour, minutes, seconds := now.Clock()
 		encryptedExtension := ""
-		if key != nil && *key != "" {
+		if encrypted {
 			encryptedExtension = ".enc"
 		}
 		archiveFilename = fmt.Sprintf("backup-%d%02d%02d_%02d%02d%02d.tar.gz%s", year, month, day, hour, minutes, seconds, encryptedExtension)
 	}
 
-	err = os.Rename(tmpArchiveFilename, archiveFilename)
-	if err != nil {
-		return fmt.Errorf("Unable to create the backup file: %s\n", err)
+	if dryRun {
+		domain.PrintDryRun("Write the manifest of the backup (%s)", manifestFilename)
+		for _, entry := range configEntries {
+			domain.PrintDryRun("Archive %s into %s", entry.File, entry.Name)
+		}
+		for _, dump := range dumps {
+			cmd, err := dump.DB.dumpCommand(dump.Database, dump.NoLock, dump.Verbose)
+			if err != nil {
+				return err
+			}
+			domain.PrintDryRun("%s > %s", cmd, dump.Path)
+		}
+		for _, entry := range fileEntries {
+			domain.PrintDryRun("Archive %s into %s", entry.File, entry.Name)
+		}
+		domain.PrintDryRun("Write the checksums of the backup (%s)", checksumsFilename)
+		if encrypted {
+			domain.PrintDryRun("Encrypt the archive %s", encryption)
+		}
+		if _, err := os.Stat(archiveFilename); err == nil {
+			domain.PrintDryRun("Overwrite %s with the backup", archiveFilename)
+		} else {
+			domain.PrintDryRun("Write the backup to %s", archiveFilename)
+		}
+		return nil
 	}
 
-	// clean tmp
-	// err = os.RemoveAll(backupDir)
-	// if err != nil {
-	// 	return fmt.Errorf("Unable to remove temp folder: %s\n", err)
-	// }
-
-	fmt.Printf("\n %s Done\n", color.GreenString("✓"))
-	return nil
-}
-
-func makeDump(ctx domain.ExecutionContext, dbBackup domain.DatabaseBackupConfig, backupDir string, verbose bool) error {
-
-	// fetch the container id for db
-	containerID, err := utils.GetContainerID(dbBackup.Container, ctx)
+	manifestContent, err := json.MarshalIndent(manifest, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	// get the container config
-	config, err := utils.GetContainerConfig(containerID, ctx)
+	// the archive is written into a tmp file next to the backup, renamed once complete
+	out, err := ioutil.TempFile(filepath.Dir(archiveFilename), "."+filepath.Base(archiveFilename)+".")
 	if err != nil {
-		return err
+		return fmt.Errorf("Unable to create the backup file: %s\n", err)
 	}
+	defer os.Remove(out.Name())
 
-	// get the type of DB to backup
-	// check from config or try to find it with the image name
-	dbType := dbBackup.Type
-	if dbType == "" {
-		if strings.Contains(config.Image, "mysql") {
-			dbType = "mysql"
-		} else if strings.Contains(config.Image, "mariadb") {
-			dbType = "mariadb"
-		} else if strings.Contains(config.Image, "postgres") {
-			dbType = "postgres"
-		} else if strings.Contains(config.Image, "mongo") {
-			dbType = "mongo"
+	err = writeArchive(out, encrypt, func(archive *archiveWriter) error {
+		if err := archive.addContent(manifestFilename, manifestContent); err != nil {
+			return err
 		}
-	}
-
-	if dbType == "mysql" || dbType == "mariadb" {
-		if dbBackup.AllDatabases {
-			return mysqlDumpAllDatabases(containerID, config.Env, backupDir, dbBackup.NoLock, verbose)
+		for _, entry := range configEntries {
+			if err := archive.addFile(entry.Name, entry.File); err != nil {
+				return err
+			}
 		}
-		return mysqlDump(containerID, config.Env, backupDir, dbBackup.Databases, dbBackup.NoLock, verbose)
-	} else if dbType == "postgres" {
-		return postgresDump(containerID, config.Env, backupDir, dbBackup.Databases, verbose)
-	} else if dbType == "mongo" {
-		return mongoDump(path.Join(backupDir, "mongodb.archive"), containerID, config.Env, backupDir)
-	} else {
-		return fmt.Errorf("\nError: unsupported database (only MySQL, MariaDB, PostgreSQL or MongoDB)")
+		for _, dump := range dumps {
+			if err := dump.streamInto(archive); err != nil {
+				return fmt.Errorf("Unable to backup databases: %s", err)
+			}
+		}
+		for _, entry := range fileEntries {
+			if err := archive.addFile(entry.Name, entry.File); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if closeErr := out.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("Unable to create the backup file: %s\n", closeErr)
 	}
-}
-
-func mysqlDump(containerId string, env domain.DockerContainerEnv, backupDir string, databases []string, noLock bool, verbose bool) error {
-
-	password := ""
-	if value, ok := env["MYSQL_ROOT_PASSWORD"]; ok {
-		password = value
+	if err != nil {
+		return err
 	}
 
-	mysqlDatabases := []string{"db"}
-	if len(databases) > 0 {
-		mysqlDatabases = databases
-	} else {
-		if value, ok := env["MYSQL_DATABASE"]; ok {
-			mysqlDatabases = []string{value}
-		}
+	err = os.Rename(out.Name(), archiveFilename)
+	if err != nil {
+		return fmt.Errorf("Unable to create the backup file: %s\n", err)
 	}
 
-	for _, database := range mysqlDatabases {
-		cmdArgs := []string{"docker", "exec", "-i", containerId, "mysqldump", fmt.Sprintf("--password=%s", password), database}
-		if noLock {
-			cmdArgs = []string{"docker", "exec", "-i", containerId, "mysqldump", "--single-transaction", "--skip-lock-tables", fmt.Sprintf("--password=%s", password), database}
-		}
+	fmt.Printf("\n %s Done\n", color.GreenString("✓"))
+	return nil
+}
 
-		cmd := domain.NewCommand(cmdArgs, verbose)
+// backupEntry is a file to copy into the archive
+type backupEntry struct {
+	Name string // entry of the archive
+	File string
+}
 
-		file, err := ioutil.TempFile(backupDir, "plizdump")
-		if err != nil {
-			fmt.Println("Unable to create a tmp file:")
-			return err
-		}
-		defer file.Close()
+// backupDump is a dump to stream into the archive
+type backupDump struct {
+	manifestDump
+	DB      database
+	NoLock  bool
+	Verbose bool
+}
 
-		if err := cmd.WriteResultToFile(file); err != nil {
-			os.Remove(file.Name())
-			return err
-		}
+// streamInto pipes the output of the dump command into the entry of the archive
+func (dump backupDump) streamInto(archive *archiveWriter) error {
+	cmd, err := dump.DB.dumpCommand(dump.Database, dump.NoLock, dump.Verbose)
+	if err != nil {
+		return err
+	}
 
-		os.Rename(file.Name(), path.Join(backupDir, database+".sql"))
+	// the output of the command is the content of the entry: the 'Executing' line is printed on the terminal
+	if cmd.Verbose {
+		fmt.Printf("%s %s\n", color.MagentaString("Executing:"), cmd)
+		cmd.Verbose = false
 	}
 
-	return nil
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		writer.CloseWithError(cmd.ExecuteWithOutput(writer, os.Stderr))
+		close(done)
+	}()
+
+	err = archive.addStream(dump.Path, reader)
+	// stops the command if the archive can't be written
+	reader.CloseWithError(err)
+	<-done
+	return err
 }
 
-func mysqlDumpAllDatabases(containerId string, env domain.DockerContainerEnv, backupDir string, noLock bool, verbose bool) error {
-	password := ""
-	if value, ok := env["MYSQL_ROOT_PASSWORD"]; ok {
-		password = value
+// backupEncryption returns the function encrypting the backup (nil if not encrypted) and its description:
+// with the password if set, else with the recipients of the config
+func backupEncryption(key *string) (func(in io.Reader, out io.Writer) error, string, error) {
+	if key != nil && *key != "" {
+		return func(in io.Reader, out io.Writer) error {
+			return utils.Encrypt(in, out, []byte(*key))
+		}, "with the password", nil
 	}
 
-	cmdArgs := []string{"docker", "exec", "-i", containerId, "mysqldump", fmt.Sprintf("--password=%s", password), "--all-databases"}
-	if noLock {
-		cmdArgs = []string{"docker", "exec", "-i", containerId, "mysqldump", fmt.Sprintf("--password=%s", password), "--all-databases", "--skip-lock-tables"}
+	recipients := []utils.PublicKey{}
+	for _, recipient := range config.Get().BackupConfig.Recipients {
+		publicKey, err := utils.ParsePublicKey(recipient)
+		if err != nil {
+			return nil, "", err
+		}
+		recipients = append(recipients, publicKey)
+	}
+	if len(recipients) == 0 {
+		return nil, "", nil
 	}
 
-	cmd := domain.NewCommand(cmdArgs, verbose)
+	return func(in io.Reader, out io.Writer) error {
+		return utils.EncryptToRecipients(in, out, recipients)
+	}, fmt.Sprintf("for %d recipient(s)", len(recipients)), nil
+}
 
-	file, err := ioutil.TempFile(backupDir, "plizdump")
-	if err != nil {
-		fmt.Println("Unable to create a tmp file:")
-		return err
+// writeArchive calls write with the writer of the archive, encrypted on the fly if encrypt is set
+func writeArchive(out io.Writer, encrypt func(in io.Reader, out io.Writer) error, write func(archive *archiveWriter) error) error {
+	if encrypt == nil {
+		archive := newArchiveWriter(out)
+		if err := write(archive); err != nil {
+			return err
+		}
+		return archive.close()
 	}
-	defer file.Close()
 
-	if err := cmd.WriteResultToFile(file); err != nil {
-		os.Remove(file.Name())
-		return err
+	reader, writer := io.Pipe()
+	encryption := make(chan error)
+	go func() {
+		err := encrypt(reader, out)
+		// unblocks the archive writer if the encryption failed
+		reader.CloseWithError(err)
+		encryption <- err
+	}()
+
+	archive := newArchiveWriter(writer)
+	err := write(archive)
+	if err == nil {
+		err = archive.close()
 	}
+	writer.CloseWithError(err)
 
-	os.Rename(file.Name(), path.Join(backupDir, "dump.sql"))
-
-	return nil
+	if encryptionErr := <-encryption; err == nil && encryptionErr != nil {
+		err = fmt.Errorf("Unable to encrypt the archive: %s", encryptionErr)
+	}
+	return err
 }
 
-func postgresDump(containerId string, env domain.DockerContainerEnv, backupDir string, databases []string, verbose bool) error {
-
-	password := ""
-	if value, ok := env["POSTGRES_PASSWORD"]; ok {
-		password = value
+// prepareDumps gets the container of the db and its type (from config or guessed with the image name),
+// and lists the dumps of its databases: '<database>.sql' (or '.dump' for PostgreSQL), or a single dump of all the databases.
+// Without databases, the default one of the image is dumped.
+func prepareDumps(ctx domain.ExecutionContext, dbBackup domain.DatabaseBackupConfig, verbose bool) (manifestDatabase, []backupDump, error) {
+	db, err := findDatabase(ctx, dbBackup.Container, dbBackup.Type)
+	if err != nil {
+		return manifestDatabase{}, nil, err
 	}
 
-	user := "postgres"
-	if value, ok := env["POSTGRES_USER"]; ok {
-		user = value
-	}
+	dbManifest := manifestDatabase{Service: db.Service, Type: db.Type, Image: db.Image, Version: db.serverVersion(), Dumps: []manifestDump{}}
 
-	postgresDatabases := []string{"db"}
-	if len(databases) > 0 {
-		postgresDatabases = databases
-	} else {
-		if value, ok := env["POSTGRES_DB"]; ok {
-			postgresDatabases = []string{value}
+	databases := dbBackup.Databases
+	filename := ""
+	noLock := false
+	switch db.Type {
+	case "mysql", "mariadb":
+		if dbBackup.AllDatabases {
+			databases = []string{""}
+			filename = "dump.sql"
 		}
+		noLock = dbBackup.NoLock
+	case "postgres":
+	case "mongo":
+		databases = []string{""}
+		filename = "mongodb.archive"
+		verbose = true
+	default:
+		return dbManifest, nil, fmt.Errorf("\nError: unsupported database (only MySQL, MariaDB, PostgreSQL or MongoDB)")
 	}
 
-	for _, database := range postgresDatabases {
-		cmd := domain.NewCommand([]string{"docker", "exec", "-i", "-e", fmt.Sprintf("PGPASSWORD=\"%s\"", password), containerId, "pg_dump", "-Fc", fmt.Sprintf("--username=%s", user), database}, verbose)
-
-		file, err := ioutil.TempFile(backupDir, "plizdump")
+	if len(databases) == 0 {
+		name, err := db.resolveDefaultDatabase(nil)
 		if err != nil {
-			fmt.Println("Unable to create a tmp file:")
-			return err
+			return dbManifest, nil, err
 		}
-		defer file.Close()
+		databases = []string{name}
+	}
 
-		if err := cmd.WriteResultToFile(file); err != nil {
-			os.Remove(file.Name())
-			return err
+	dumps := []backupDump{}
+	for _, name := range databases {
+		file := filename
+		if file == "" {
+			file = name + db.dumpExtension()
 		}
-
-		os.Rename(file.Name(), path.Join(backupDir, database+".dump"))
+		dump := manifestDump{Path: path.Join("databases", db.Service, file), Database: name}
+		dbManifest.Dumps = append(dbManifest.Dumps, dump)
+		dumps = append(dumps, backupDump{manifestDump: dump, DB: db, NoLock: noLock, Verbose: verbose})
 	}
 
-	return nil
+	return dbManifest, dumps, nil
 }
 
-func mongoDump(destination string, containerId string, env domain.DockerContainerEnv, backupDir string) error {
-
-	cmd := domain.NewCommand([]string{"docker", "exec", "-i", containerId, "mongodump", "--archive", "--gzip"}, true)
+// dumpToFile writes the output of the dump command into a tmp file of backupDir, then moves it to destination
+func dumpToFile(cmd domain.Command, backupDir string, destination string) error {
+	if domain.IsDryRun() {
+		domain.PrintDryRun("%s > %s", cmd, destination)
+		return nil
+	}
 
 	file, err := ioutil.TempFile(backupDir, "plizdump")
 	if err != nil {
@@ -336,7 +351,5 @@ func mongoDump(destination string, containerId string, env domain.DockerContaine
 		return err
 	}
 
-	os.Rename(file.Name(), destination)
-
-	return nil
+	return os.Rename(file.Name(), destination)
 }
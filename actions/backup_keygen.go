@@ -0,0 +1,49 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"time"
+	"webup/pliz/domain"
+	"webup/pliz/utils"
+
+	"github.com/fatih/color"
+)
+
+// BackupKeygenActionHandler generates a key pair to encrypt the backups: the private key is written
+// into the identity file (to stdout if empty), the public key is the recipient to add to pliz.yml
+func BackupKeygenActionHandler(output string) error {
+	key, err := utils.GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	content := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n", time.Now().UTC().Format(time.RFC3339), key.Public(), key)
+
+	if output == "" {
+		fmt.Print(content)
+		return nil
+	}
+
+	if domain.IsDryRun() {
+		domain.PrintDryRun("Write the private key to %s", output)
+		return nil
+	}
+
+	// an existing identity is never overwritten: the backups encrypted for it couldn't be decrypted anymore
+	file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf(" %s Private key written to %s (keep it secret, it's required to restore the backups)\n", color.GreenString("✓"), output)
+	fmt.Printf("\nAdd the public key to 'backup.recipients' in pliz.yml:\n%s\n", key.Public())
+	return nil
+}
@@ -0,0 +1,335 @@
+package actions
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"webup/pliz/domain"
+
+	"github.com/fatih/color"
+)
+
+// InspectBackupActionHandler lists the content of a backup (config files, files and database dumps)
+func InspectBackupActionHandler(file string, key *string, identity *string) error {
+	reader, err := openBackup(file, key, identity)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	// the size of a streamed entry is set in its header once read
+	sections := map[string][]*tar.Header{}
+	manifest, err := readBackup(reader, func(header *tar.Header, manifest *backupManifest, entry io.Reader) error {
+		if header.FileInfo().IsDir() {
+			return nil
+		}
+		section := strings.SplitN(header.Name, "/", 2)[0]
+		sections[section] = append(sections[section], header)
+		return nil
+	})
+	if err != nil {
+		return readBackupError(file, err)
+	}
+
+	// read until the end to check the HMAC of an encrypted backup
+	if _, err := io.Copy(ioutil.Discard, reader); err != nil {
+		return fmt.Errorf("Unable to decrypt the file %s\n%s\n", file, err)
+	}
+
+	if manifest != nil {
+		fmt.Printf("Backup of '%s' (env '%s') made on %s with pliz %s\n", manifest.Project, manifest.Env, manifest.CreatedAt.Format(time.RFC3339), manifest.PlizVersion)
+	} else {
+		fmt.Printf("%s: The backup has no manifest (made by an older version of pliz)\n", color.YellowString("Warning"))
+	}
+
+	fmt.Printf("\n %s Databases\n", color.YellowString("▶"))
+	for _, header := range sortedHeaders(sections["databases"]) {
+		description := ""
+		if manifest != nil {
+			if dbManifest, dump := manifest.dump(header.Name); dump != nil {
+				database := dump.Database
+				if database == "" {
+					database = "all databases"
+				}
+				description = fmt.Sprintf("%s, %s (%s)", database, dbManifest.Type, dbManifest.Image)
+				if dbManifest.Version != "" {
+					description += " " + dbManifest.Version
+				}
+			}
+		}
+		fmt.Printf("   %s  %s  %s\n", header.Name, formatSize(header.Size), description)
+	}
+
+	fmt.Printf("\n %s Config files\n", color.YellowString("▶"))
+	for _, header := range sortedHeaders(sections["config"]) {
+		fmt.Printf("   %s  %s\n", strings.TrimPrefix(header.Name, "config/"), formatSize(header.Size))
+	}
+
+	fmt.Printf("\n %s Files\n", color.YellowString("▶"))
+	for _, header := range sortedHeaders(sections["files"]) {
+		fmt.Printf("   %s  %s\n", strings.TrimPrefix(header.Name, "files/"), formatSize(header.Size))
+	}
+
+	count := 0
+	var total int64
+	for _, headers := range sections {
+		count += len(headers)
+		for _, header := range headers {
+			total += header.Size
+		}
+	}
+	fmt.Printf("\n%d file(s), %s\n", count, formatSize(total))
+
+	return nil
+}
+
+// VerifyBackupActionHandler checks that the backup can be read (decryption, HMAC, tarball) and that the checksums
+// match the manifest. With testRestore, the dumps are imported into throwaway containers of the database images.
+func VerifyBackupActionHandler(file string, key *string, identity *string, testRestore bool, verbose bool) error {
+	reader, err := openBackup(file, key, identity)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	problems := []string{}
+	seen := map[string]bool{}
+	scratchDatabases := map[string]database{}
+	defer func() {
+		for _, db := range scratchDatabases {
+			db.remove()
+		}
+	}()
+
+	// the throwaway containers are labelled with the pid, to be removed on Ctrl-C (even while they are started)
+	run := fmt.Sprintf("%d", os.Getpid())
+	if testRestore && !domain.IsDryRun() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer func() {
+			signal.Stop(signals)
+			close(signals)
+		}()
+		go func() {
+			if _, ok := <-signals; ok {
+				fmt.Printf("\n → Removing the throwaway containers\n")
+				removeScratchContainers(run)
+				os.Exit(130)
+			}
+		}()
+	}
+
+	manifest, err := readBackup(reader, func(header *tar.Header, manifest *backupManifest, entry io.Reader) error {
+		if header.FileInfo().IsDir() {
+			return nil
+		}
+		seen[header.Name] = true
+
+		if testRestore && manifest != nil && strings.HasPrefix(header.Name, "databases/") {
+			if err := testDumpRestoration(manifest, header.Name, entry, scratchDatabases, run, verbose); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", header.Name, err))
+				fmt.Printf(" %s %s\n", color.RedString("✗"), header.Name)
+				return nil
+			}
+		}
+
+		if verbose || manifest == nil {
+			fmt.Printf(" %s %s\n", color.GreenString("✓"), header.Name)
+		}
+		return nil
+	})
+	if checksumErr, ok := err.(*checksumError); ok {
+		problems = append(problems, checksumErr.Problems...)
+	} else if err != nil {
+		return readBackupError(file, err)
+	}
+
+	// read until the end to check the HMAC of an encrypted backup
+	if _, err := io.Copy(ioutil.Discard, reader); err != nil {
+		return fmt.Errorf("Unable to decrypt the file %s\n%s\n", file, err)
+	}
+
+	if manifest == nil {
+		fmt.Printf("\n%s: The backup has no manifest (made by an older version of pliz), the checksums can't be verified\n", color.YellowString("Warning"))
+		if testRestore {
+			fmt.Printf("%s: The restoration of the dumps can't be tested without manifest\n", color.YellowString("Warning"))
+		}
+	}
+
+	if len(problems) > 0 {
+		fmt.Println("")
+		for _, problem := range problems {
+			fmt.Printf("%s %s\n", color.RedString("✗"), problem)
+		}
+		return fmt.Errorf("The backup %s is invalid (%d error(s))", file, len(problems))
+	}
+
+	fmt.Printf("\n %s The backup %s is valid (%d file(s))\n", color.GreenString("✓"), file, len(seen))
+	return nil
+}
+
+// testDumpRestoration imports the dump into a throwaway container of the image of its db (started once per db)
+func testDumpRestoration(manifest *backupManifest, entry string, reader io.Reader, scratchDatabases map[string]database, run string, verbose bool) error {
+	dbManifest, dump := manifest.dump(entry)
+	if dump == nil {
+		return fmt.Errorf("The dump is not described in the manifest of the backup")
+	}
+
+	if domain.IsDryRun() {
+		domain.PrintDryRun("Import %s into a throwaway container of %s", entry, dbManifest.Image)
+		return nil
+	}
+
+	db, ok := scratchDatabases[dbManifest.Service]
+	if !ok {
+		fmt.Printf(" → Starting a throwaway container of %s\n", dbManifest.Image)
+		var err error
+		db, err = startScratchDatabase(*dbManifest, run, verbose)
+		if err != nil {
+			return err
+		}
+		scratchDatabases[dbManifest.Service] = db
+	}
+
+	if dump.Database != "" {
+		var stderr bytes.Buffer
+		if err := db.createDatabaseCommand(dump.Database).ExecuteWithOutput(ioutil.Discard, &stderr); err != nil {
+			return fmt.Errorf("Unable to create the database '%s': %v\n%s", dump.Database, err, strings.TrimSpace(stderr.String()))
+		}
+	}
+
+	cmd, err := db.importCommand(filepath.Ext(entry), dump.Database, verbose)
+	if err != nil {
+		return err
+	}
+	if err := cmd.ExecuteWithStdin(reader); err != nil {
+		return err
+	}
+
+	fmt.Printf(" %s %s restored into %s\n", color.GreenString("✓"), entry, dbManifest.Image)
+	return nil
+}
+
+// scratchLabel labels the throwaway containers of 'backup verify --test-restore' (its value is the pid of pliz)
+const scratchLabel = "pliz.scratch"
+
+// startScratchDatabase runs a throwaway container of the image of the db (labelled with the run) and waits until the server is ready
+func startScratchDatabase(dbManifest manifestDatabase, run string, verbose bool) (database, error) {
+	db := database{Service: dbManifest.Service, Type: dbManifest.Type, Image: dbManifest.Image, Env: domain.DockerContainerEnv{}}
+
+	password, err := randomPassword()
+	if err != nil {
+		return db, err
+	}
+
+	env := []string{}
+	switch db.Type {
+	case "mysql", "mariadb":
+		db.Env["MYSQL_ROOT_PASSWORD"] = password
+		env = append(env, "MYSQL_ROOT_PASSWORD="+password)
+	case "postgres":
+		db.Env["POSTGRES_PASSWORD"] = password
+		env = append(env, "POSTGRES_PASSWORD="+password)
+	case "mongo":
+	default:
+		return db, db.unsupportedError()
+	}
+
+	args := []string{"docker", "run", "-d", "--rm", "--label", fmt.Sprintf("%s=%s", scratchLabel, run)}
+	for _, variable := range env {
+		args = append(args, "-e", strings.SplitN(variable, "=", 2)[0])
+	}
+	args = append(args, db.Image)
+
+	cmd := domain.NewCommand(args, verbose)
+	cmd.Env = env
+	containerID, err := cmd.GetResult()
+	if err != nil {
+		return db, err
+	}
+	db.ContainerID = containerID
+
+	// the server is ready when it accepts TCP connections (not during the initialization of the image)
+	var ready []string
+	switch db.Type {
+	case "mysql", "mariadb":
+		ready = []string{"mysqladmin", "ping", "--user=root", "--protocol=tcp", "--host=127.0.0.1"}
+	case "postgres":
+		ready = []string{"pg_isready", "--host=127.0.0.1", "--username=postgres"}
+	case "mongo":
+		ready = []string{"sh", "-c", "mongosh --quiet --eval 1 || mongo --quiet --eval 1"}
+	}
+	for i := 0; i < 120; i++ {
+		if _, err := db.execCommand(false, ready, false).GetResult(); err == nil {
+			return db, nil
+		}
+		time.Sleep(time.Second)
+	}
+
+	db.remove()
+	return db, fmt.Errorf("The throwaway container of %s is not ready after 2 minutes", db.Image)
+}
+
+// createDatabaseCommand returns the command creating the database (MySQL, MariaDB and PostgreSQL only)
+func (db database) createDatabaseCommand(name string) domain.Command {
+	if db.Type == "postgres" {
+		return db.execCommand(false, []string{"createdb", fmt.Sprintf("--username=%s", db.postgresUser()), name}, false)
+	}
+	return db.execCommand(false, []string{"mysql", "--user=root", "-e", fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", name)}, false)
+}
+
+// remove stops and removes the container of the db
+func (db database) remove() {
+	if db.ContainerID == "" {
+		return
+	}
+	domain.NewCommand([]string{"docker", "rm", "-f", db.ContainerID}, false).ExecuteWithOutput(ioutil.Discard, os.Stderr)
+}
+
+// removeScratchContainers removes the throwaway containers of the run
+func removeScratchContainers(run string) {
+	ids, err := domain.NewCommand([]string{"docker", "ps", "-aq", "--filter", fmt.Sprintf("label=%s=%s", scratchLabel, run)}, false).GetResult()
+	if err != nil || ids == "" {
+		return
+	}
+	domain.NewCommand(append([]string{"docker", "rm", "-f"}, strings.Fields(ids)...), false).ExecuteWithOutput(ioutil.Discard, os.Stderr)
+}
+
+func randomPassword() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+func formatSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// sortedHeaders sorts the entries by name
+func sortedHeaders(headers []*tar.Header) []*tar.Header {
+	sort.Slice(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+	return headers
+}
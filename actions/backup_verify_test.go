@@ -0,0 +1,108 @@
+package actions
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyBackup(t *testing.T) {
+	tests := []struct {
+		name     string
+		write    func(t *testing.T, file string)
+		valid    bool
+		message  string   // contained in the error (any error if empty)
+		problems []string // printed
+	}{
+		{
+			name:  "valid",
+			write: func(t *testing.T, file string) { writeBackupFile(t, file, nil) },
+			valid: true,
+		},
+		{
+			name: "modified byte",
+			write: func(t *testing.T, file string) {
+				writeBackupFile(t, file, nil)
+				flipByte(t, file)
+			},
+		},
+		{
+			name: "invalid checksum",
+			write: func(t *testing.T, file string) {
+				writeBackupFile(t, file, func(archive *archiveWriter) {
+					archive.checksums[0].SHA256 = strings.Repeat("0", 64)
+				})
+			},
+			message:  "The backup backup.tar.gz is invalid (1 error(s))",
+			problems: []string{"Invalid checksum for config/.env"},
+		},
+		{
+			name:     "missing checksums",
+			write:    writeBackupWithoutChecksums,
+			message:  "The backup backup.tar.gz is invalid (3 error(s))",
+			problems: []string{"checksums.json is missing", "config/.env is not described in the manifest of the backup"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inTempDir(t, nil)
+			test.write(t, "backup.tar.gz")
+
+			var err error
+			output := captureStdout(t, func() {
+				err = VerifyBackupActionHandler("backup.tar.gz", nil, nil, false, false)
+			})
+
+			if test.valid {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !strings.Contains(output, "The backup backup.tar.gz is valid (2 file(s))") {
+					t.Errorf("unexpected output:\n%s", output)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Fatalf("expected an error containing '%s', got %v", test.message, err)
+			}
+			for _, problem := range test.problems {
+				if !strings.Contains(output, problem) {
+					t.Errorf("expected the problem '%s' in the output:\n%s", problem, output)
+				}
+			}
+		})
+	}
+}
+
+func TestInspectBackup(t *testing.T) {
+	inTempDir(t, nil)
+	writeBackupFile(t, "backup.tar.gz", nil)
+
+	var err error
+	output := captureStdout(t, func() {
+		err = InspectBackupActionHandler("backup.tar.gz", nil, nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, line := range []string{"   .env  15 B", "   storage/app.txt  8 B", "2 file(s), 23 B"} {
+		if !strings.Contains(output, line) {
+			t.Errorf("expected '%s' in the output:\n%s", line, output)
+		}
+	}
+
+	// the content of a corrupted backup is not listed
+	writeBackupFile(t, "backup.tar.gz", func(archive *archiveWriter) {
+		archive.checksums[0].SHA256 = strings.Repeat("0", 64)
+	})
+	output = captureStdout(t, func() {
+		err = InspectBackupActionHandler("backup.tar.gz", nil, nil)
+	})
+	if err == nil || !strings.Contains(err.Error(), "Invalid checksum for config/.env") {
+		t.Errorf("expected a checksum error, got %v", err)
+	}
+	if strings.Contains(output, "storage/app.txt") {
+		t.Errorf("the content of the corrupted backup is listed:\n%s", output)
+	}
+}
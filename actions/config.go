@@ -0,0 +1,253 @@
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"webup/pliz/config"
+	"webup/pliz/domain"
+
+	"github.com/fatih/color"
+	"gopkg.in/yaml.v3"
+)
+
+type configView struct {
+	Containers                  containersView             `json:"containers" yaml:"containers"`
+	StartupContainer            string                     `json:"startup_container" yaml:"startup_container"`
+	AdditionalStartupContainers []string                   `json:"additional_startup_containers" yaml:"additional_startup_containers"`
+	ConfigFiles                 map[string]string          `json:"config_files" yaml:"config_files"`
+	DefaultTasksCheck           string                     `json:"default_tasks_check" yaml:"default_tasks_check"`
+	InstallTasks                []domain.TaskID            `json:"install_tasks" yaml:"install_tasks"`
+	Tasks                       []taskView                 `json:"tasks" yaml:"tasks"`
+	Checklist                   []string                   `json:"checklist" yaml:"checklist"`
+	Backup                      backupView                 `json:"backup" yaml:"backup"`
+	Environments                map[string]environmentView `json:"environments" yaml:"environments"`
+}
+
+type environmentView struct {
+	Protected bool `json:"protected" yaml:"protected"`
+}
+
+type containersView struct {
+	Proxy   string `json:"proxy" yaml:"proxy"`
+	App     string `json:"app" yaml:"app"`
+	Builder string `json:"builder" yaml:"builder"`
+	Db      string `json:"db" yaml:"db"`
+}
+
+type taskView struct {
+	Name        domain.TaskID     `json:"name" yaml:"name"`
+	Origin      domain.TaskOrigin `json:"origin" yaml:"origin"`
+	Description string            `json:"description,omitempty" yaml:"description,omitempty"`
+	Container   string            `json:"container" yaml:"container"`
+	Mode        string            `json:"mode,omitempty" yaml:"mode,omitempty"`
+	Command     []string          `json:"command" yaml:"command"`
+	DependsOn   []domain.TaskID   `json:"depends_on,omitempty" yaml:"depends_on,omitempty"`
+	Env         map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	WorkingDir  string            `json:"workdir,omitempty" yaml:"workdir,omitempty"`
+	User        string            `json:"user,omitempty" yaml:"user,omitempty"`
+	Check       *checkView        `json:"check,omitempty" yaml:"check,omitempty"`
+}
+
+type checkView struct {
+	Type        string   `json:"type" yaml:"type"`
+	UpdatedFile string   `json:"updated_file,omitempty" yaml:"updated_file,omitempty"`
+	CompareTo   string   `json:"compare_to,omitempty" yaml:"compare_to,omitempty"`
+	Files       []string `json:"files,omitempty" yaml:"files,omitempty"`
+	Target      string   `json:"target,omitempty" yaml:"target,omitempty"`
+	Path        string   `json:"path,omitempty" yaml:"path,omitempty"`
+	Command     []string `json:"command,omitempty" yaml:"command,omitempty"`
+}
+
+type backupView struct {
+	Files      []string             `json:"files" yaml:"files"`
+	Databases  []databaseBackupView `json:"databases" yaml:"databases"`
+	Recipients []string             `json:"recipients,omitempty" yaml:"recipients,omitempty"`
+}
+
+type databaseBackupView struct {
+	Container    string   `json:"container" yaml:"container"`
+	Type         string   `json:"type,omitempty" yaml:"type,omitempty"`
+	NoLock       bool     `json:"no_lock" yaml:"no_lock"`
+	AllDatabases bool     `json:"all_databases" yaml:"all_databases"`
+	Databases    []string `json:"databases,omitempty" yaml:"databases,omitempty"`
+}
+
+// ConfigShowActionHandler prints the resolved config (defaults and overrides applied) as YAML or JSON
+func ConfigShowActionHandler(format string) error {
+	view := newConfigView(config.Get())
+
+	switch format {
+	case "yaml", "yml":
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(view); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(view)
+	}
+
+	return fmt.Errorf("Unsupported format '%s' (yaml or json)", format)
+}
+
+// ConfigValidateActionHandler checks the config and prints all the errors found
+func ConfigValidateActionHandler(ctx domain.ExecutionContext) error {
+	errs := config.Validate(ctx)
+	if len(errs) == 0 {
+		fmt.Printf("%s The config is valid\n", color.GreenString("✓"))
+		return nil
+	}
+
+	for _, err := range errs {
+		fmt.Printf("%s %v\n", color.RedString("✗"), err)
+	}
+
+	return fmt.Errorf("%d error(s) found in the config", len(errs))
+}
+
+// ConfigMigrateActionHandler updates pliz.yml and its overlays to the current version (the result is printed in dry-run mode)
+func ConfigMigrateActionHandler() error {
+	dryRun := domain.IsDryRun()
+
+	migratedFiles, err := config.Migrate(!dryRun)
+	if err != nil {
+		return err
+	}
+
+	if len(migratedFiles) == 0 {
+		fmt.Printf("%s The config is already up to date (version %d)\n", color.GreenString("✓"), config.CurrentVersion)
+		return nil
+	}
+
+	for _, file := range migratedFiles {
+		fmt.Printf("%s:\n", file.Filename)
+		for _, change := range file.Changes {
+			fmt.Printf(" → %s\n", change)
+		}
+
+		if dryRun {
+			domain.PrintDryRun("Write %s (previous content saved into %s.bak):", file.Filename, file.Filename)
+			fmt.Printf("\n%s\n", file.Content)
+			continue
+		}
+
+		fmt.Printf(" %s %s migrated to the version %d (previous content saved into %s.bak)\n\n", color.GreenString("✓"), file.Filename, config.CurrentVersion, file.Filename)
+	}
+
+	return nil
+}
+
+func newConfigView(cfg domain.Config) configView {
+	view := configView{
+		Containers: containersView{
+			Proxy:   cfg.Containers.Proxy,
+			App:     cfg.Containers.App,
+			Builder: cfg.Containers.Builder,
+			Db:      cfg.Containers.Db,
+		},
+		StartupContainer:            cfg.StartupContainer,
+		AdditionalStartupContainers: append([]string{}, cfg.AdditionalStartupContainers...),
+		ConfigFiles:                 map[string]string{},
+		DefaultTasksCheck:           string(cfg.DefaultTasksCheck),
+		InstallTasks:                append([]domain.TaskID{}, cfg.InstallTasks...),
+		Tasks:                       []taskView{},
+		Checklist:                   append([]string{}, cfg.Checklist...),
+		Backup:                      backupView{Files: append([]string{}, cfg.BackupConfig.Files...), Databases: []databaseBackupView{}, Recipients: cfg.BackupConfig.Recipients},
+		Environments:                map[string]environmentView{},
+	}
+
+	for name, environment := range cfg.Environments {
+		view.Environments[name] = environmentView{Protected: environment.Protected}
+	}
+
+	for _, db := range cfg.BackupConfig.Databases {
+		view.Backup.Databases = append(view.Backup.Databases, databaseBackupView{
+			Container:    db.Container,
+			Type:         db.Type,
+			NoLock:       db.NoLock,
+			AllDatabases: db.AllDatabases,
+			Databases:    db.Databases,
+		})
+	}
+
+	for _, configFile := range cfg.ConfigFiles {
+		view.ConfigFiles[configFile.Sample] = configFile.Target
+	}
+
+	ids := []string{}
+	for id := range cfg.Tasks {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		task := cfg.Tasks[domain.TaskID(id)]
+
+		container := "none"
+		mode := ""
+		if task.Container != nil {
+			container = *task.Container
+			mode = string(domain.RunMode)
+			if task.Mode != "" {
+				mode = string(task.Mode)
+			}
+		}
+
+		view.Tasks = append(view.Tasks, taskView{
+			Name:        task.Name,
+			Origin:      task.Origin,
+			Description: task.Description,
+			Container:   container,
+			Mode:        mode,
+			Command:     redactArgs(task.CommandArgs),
+			DependsOn:   task.Dependencies,
+			Env:         redactEnv(task.Env),
+			WorkingDir:  task.WorkingDir,
+			User:        task.User,
+			Check:       newCheckView(task.ExecutionCheck),
+		})
+	}
+
+	return view
+}
+
+// redactArgs hides the secrets of the args of a command (see domain.RedactSecrets)
+func redactArgs(args []string) []string {
+	redacted := []string{}
+	for _, arg := range args {
+		redacted = append(redacted, domain.RedactSecrets(arg))
+	}
+	return redacted
+}
+
+// redactEnv hides the values of the variables holding a secret (i.e. 'DB_PASSWORD')
+func redactEnv(env map[string]string) map[string]string {
+	redacted := map[string]string{}
+	for name, value := range env {
+		redacted[name] = strings.TrimPrefix(domain.RedactSecrets(name+"="+value), name+"=")
+	}
+	return redacted
+}
+
+func newCheckView(check domain.TaskExecutionCheck) *checkView {
+	switch chk := check.(type) {
+	case *domain.ModificationDateTaskExecutionCheck:
+		return &checkView{Type: "modification_date", UpdatedFile: chk.UpdatedFile, CompareTo: chk.CompareTo}
+	case *domain.ContentHashTaskExecutionCheck:
+		return &checkView{Type: "content_hash", Files: chk.Files, Target: chk.Target}
+	case *domain.MissingPathTaskExecutionCheck:
+		return &checkView{Type: "missing_path", Path: chk.Path}
+	case *domain.CommandTaskExecutionCheck:
+		return &checkView{Type: "command", Command: chk.CommandArgs}
+	case nil:
+		return nil
+	}
+
+	return &checkView{Type: fmt.Sprintf("%T", check)}
+}
@@ -0,0 +1,28 @@
+package actions
+
+import (
+	"reflect"
+	"testing"
+	"webup/pliz/domain"
+)
+
+func TestConfigViewRedactsSecrets(t *testing.T) {
+	cfg := domain.Config{Tasks: map[domain.TaskID]domain.Task{
+		"seed": {
+			Name:        "seed",
+			CommandArgs: domain.CommandArgs{"php", "artisan", "db:seed", "--password=s3cr3t"},
+			Env:         map[string]string{"DB_PASSWORD": "s3cr3t", "PGPASSWORD": "s3cr3t", "API_TOKEN": "abc", "DEBUG": "1", "OPTS": "--key=abc"},
+		},
+	}}
+
+	task := newConfigView(cfg).Tasks[0]
+
+	expectedEnv := map[string]string{"DB_PASSWORD": "****", "PGPASSWORD": "****", "API_TOKEN": "****", "DEBUG": "1", "OPTS": "--key=****"}
+	if !reflect.DeepEqual(task.Env, expectedEnv) {
+		t.Errorf("expected the env %v, got %v", expectedEnv, task.Env)
+	}
+	expectedCommand := []string{"php", "artisan", "db:seed", "--password=****"}
+	if !reflect.DeepEqual(task.Command, expectedCommand) {
+		t.Errorf("expected the command %v, got %v", expectedCommand, task.Command)
+	}
+}
@@ -0,0 +1,323 @@
+package actions
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"webup/pliz/config"
+	"webup/pliz/domain"
+	"webup/pliz/utils"
+)
+
+// database is the running container of a db service
+type database struct {
+	Service     string
+	ContainerID string
+	Type        string // mysql, mariadb, postgres or mongo
+	Image       string
+	Env         domain.DockerContainerEnv
+}
+
+// findDatabase gets the running container of the db service and its type
+// (the configured one, or guessed from the image name if empty)
+func findDatabase(ctx domain.ExecutionContext, service string, dbType string) (database, error) {
+	db := database{Service: service, Type: dbType}
+
+	containerID, err := utils.GetContainerID(service, ctx)
+	if err != nil {
+		return db, err
+	}
+	if containerID == "" {
+		return db, fmt.Errorf("The service '%s' is not running", service)
+	}
+	db.ContainerID = containerID
+
+	containerConfig, err := utils.GetContainerConfig(containerID, ctx)
+	if err != nil {
+		return db, err
+	}
+	db.Env = containerConfig.Env
+	db.Image = containerConfig.Image
+
+	if db.Type == "" {
+		db.Type = detectDatabaseType(containerConfig.Image)
+	}
+
+	return db, nil
+}
+
+// databaseBackupConfig returns the config of the db service in 'backup.databases' (only the container is set if not found)
+func databaseBackupConfig(service string) domain.DatabaseBackupConfig {
+	for _, dbBackup := range config.Get().BackupConfig.Databases {
+		if dbBackup.Container == service {
+			return dbBackup
+		}
+	}
+	return domain.DatabaseBackupConfig{Container: service}
+}
+
+// detectDatabaseType guesses the type of the db from the image name (empty if unknown)
+func detectDatabaseType(image string) string {
+	for _, dbType := range []string{"mysql", "mariadb", "postgres", "mongo"} {
+		if strings.Contains(image, dbType) {
+			return dbType
+		}
+	}
+	return ""
+}
+
+// resolveDefaultDatabase returns the database used when none is given: the first one configured for the service,
+// else the one created by the image (MYSQL_DATABASE or POSTGRES_DB in the env of its container)
+func (db database) resolveDefaultDatabase(configured []string) (string, error) {
+	if len(configured) > 0 {
+		return configured[0], nil
+	}
+
+	switch db.Type {
+	case "mysql", "mariadb":
+		if value := db.Env["MYSQL_DATABASE"]; value != "" {
+			return value, nil
+		}
+	case "postgres":
+		if value := db.Env["POSTGRES_DB"]; value != "" {
+			return value, nil
+		}
+		// the image creates the database of its user
+		return db.postgresUser(), nil
+	}
+
+	return "", fmt.Errorf("Unable to find the default database of the service '%s' (set it in 'backup.databases' or give it as an argument)", db.Service)
+}
+
+func (db database) postgresUser() string {
+	if value, ok := db.Env["POSTGRES_USER"]; ok {
+		return value
+	}
+	return "postgres"
+}
+
+// dumpExtension returns the extension of the files written by dumpCommand
+func (db database) dumpExtension() string {
+	switch db.Type {
+	case "postgres":
+		return ".dump"
+	case "mongo":
+		return ".archive"
+	}
+	return ".sql"
+}
+
+// execCommand returns the command running the client in the container of the db.
+// The password is given to docker with the environment (MYSQL_PWD or PGPASSWORD): it's not in the command line.
+func (db database) execCommand(interactive bool, client []string, verbose bool) domain.Command {
+	args := []string{"docker", "exec", "-i"}
+	if interactive {
+		args = []string{"docker", "exec", "-it"}
+	}
+
+	env := []string{}
+	switch db.Type {
+	case "mysql", "mariadb":
+		env = append(env, "MYSQL_PWD="+db.Env["MYSQL_ROOT_PASSWORD"])
+	case "postgres":
+		env = append(env, "PGPASSWORD="+db.Env["POSTGRES_PASSWORD"])
+	}
+	for _, variable := range env {
+		// '-e NAME' without value: the value is read from the environment of docker
+		args = append(args, "-e", strings.SplitN(variable, "=", 2)[0])
+	}
+
+	args = append(args, db.ContainerID)
+	args = append(args, client...)
+
+	cmd := domain.NewCommand(args, verbose)
+	cmd.Env = env
+	return cmd
+}
+
+// dumpCommand returns the command writing the dump of the database to its output.
+// With an empty name, all the databases are dumped (MySQL, MariaDB and MongoDB only).
+func (db database) dumpCommand(name string, noLock bool, verbose bool) (domain.Command, error) {
+	var client []string
+
+	switch db.Type {
+	case "mysql", "mariadb":
+		client = []string{"mysqldump", "--user=root"}
+		if noLock {
+			client = append(client, "--single-transaction", "--skip-lock-tables")
+		}
+		if name == "" {
+			client = append(client, "--all-databases")
+		} else {
+			client = append(client, name)
+		}
+	case "postgres":
+		if name == "" {
+			return domain.Command{}, fmt.Errorf("A database must be set to dump a PostgreSQL db")
+		}
+		client = []string{"pg_dump", "-Fc", fmt.Sprintf("--username=%s", db.postgresUser()), name}
+	case "mongo":
+		client = []string{"mongodump", "--archive", "--gzip"}
+		if name != "" {
+			client = append(client, fmt.Sprintf("--db=%s", name))
+		}
+	default:
+		return domain.Command{}, db.unsupportedError()
+	}
+
+	return db.execCommand(false, client, verbose), nil
+}
+
+// importCommand returns the command importing the dump read from its input.
+// The format of the dump is given by the extension of its file (.sql, .dump or .archive).
+// With an empty name, the databases are the ones of the dump (MySQL, MariaDB and MongoDB only).
+func (db database) importCommand(extension string, name string, verbose bool) (domain.Command, error) {
+	var client []string
+
+	switch db.Type {
+	case "mysql", "mariadb":
+		client = []string{"mysql", "--user=root"}
+		if name != "" {
+			client = append(client, name)
+		}
+	case "postgres":
+		if name == "" {
+			return domain.Command{}, fmt.Errorf("A database must be set to import a PostgreSQL dump")
+		}
+		if extension == ".sql" {
+			// plain SQL dump
+			client = []string{"psql", fmt.Sprintf("--username=%s", db.postgresUser()), "-d", name}
+		} else {
+			client = []string{"pg_restore", fmt.Sprintf("--username=%s", db.postgresUser()), "-d", name, "-c", "--if-exists"}
+		}
+	case "mongo":
+		client = []string{"mongorestore", "--archive", "--gzip"}
+		if name != "" {
+			client = append(client, fmt.Sprintf("--nsInclude=%s.*", name))
+		}
+	default:
+		return domain.Command{}, db.unsupportedError()
+	}
+
+	return db.execCommand(false, client, verbose), nil
+}
+
+// serverVersion returns the version printed by the server of the db (empty if unknown)
+func (db database) serverVersion() string {
+	server := map[string]string{"mysql": "mysqld", "mariadb": "mysqld", "postgres": "postgres", "mongo": "mongod"}[db.Type]
+	if server == "" {
+		return ""
+	}
+
+	version, err := domain.NewCommand([]string{"docker", "exec", db.ContainerID, server, "--version"}, false).GetResult()
+	if err != nil {
+		return ""
+	}
+	return strings.SplitN(version, "\n", 2)[0]
+}
+
+func (db database) unsupportedError() error {
+	return fmt.Errorf("Unsupported database for the service '%s' (only MySQL, MariaDB, PostgreSQL or MongoDB)", db.Service)
+}
+
+// DbShellActionHandler opens an interactive client (mysql, psql or mongosh) in the db service
+func DbShellActionHandler(ctx domain.ExecutionContext, service string) error {
+	dbBackup := databaseBackupConfig(service)
+	db, err := findDatabase(ctx, service, dbBackup.Type)
+	if err != nil {
+		return err
+	}
+
+	var client []string
+	switch db.Type {
+	case "mysql", "mariadb":
+		client = []string{"mysql", "--user=root"}
+		if database, ok := db.Env["MYSQL_DATABASE"]; ok {
+			client = append(client, database)
+		}
+	case "postgres":
+		client = []string{"psql", fmt.Sprintf("--username=%s", db.postgresUser())}
+		if database, ok := db.Env["POSTGRES_DB"]; ok {
+			client = append(client, database)
+		}
+	case "mongo":
+		// 'mongo' is the client of the images older than 6.0
+		client = []string{"sh", "-c", "command -v mongosh > /dev/null && exec mongosh || exec mongo"}
+	default:
+		return db.unsupportedError()
+	}
+
+	return db.execCommand(true, client, false).Execute()
+}
+
+// DbDumpActionHandler dumps a database of the db service into the output file (to stdout if empty)
+func DbDumpActionHandler(ctx domain.ExecutionContext, service string, name string, output string, verbose bool) error {
+	dbBackup := databaseBackupConfig(service)
+	db, err := findDatabase(ctx, service, dbBackup.Type)
+	if err != nil {
+		return err
+	}
+
+	if name == "" && db.Type != "mongo" {
+		name, err = db.resolveDefaultDatabase(dbBackup.Databases)
+		if err != nil {
+			return err
+		}
+	}
+
+	if output == "" {
+		// the output is the dump: nothing else is printed on stdout
+		cmd, err := db.dumpCommand(name, false, false)
+		if err != nil {
+			return err
+		}
+		return cmd.ExecuteWithOutput(os.Stdout, os.Stderr)
+	}
+
+	cmd, err := db.dumpCommand(name, false, verbose)
+	if err != nil {
+		return err
+	}
+
+	return dumpToFile(cmd, filepath.Dir(output), output)
+}
+
+// DbImportActionHandler imports a dump file (from stdin if '-') into a database of the db service
+func DbImportActionHandler(ctx domain.ExecutionContext, service string, file string, name string, verbose bool) error {
+	dbBackup := databaseBackupConfig(service)
+	db, err := findDatabase(ctx, service, dbBackup.Type)
+	if err != nil {
+		return err
+	}
+
+	extension := filepath.Ext(file)
+	if file == "-" {
+		extension = db.dumpExtension()
+	}
+
+	if name == "" && db.Type != "mongo" {
+		name, err = db.resolveDefaultDatabase(dbBackup.Databases)
+		if err != nil {
+			return err
+		}
+	}
+
+	cmd, err := db.importCommand(extension, name, verbose)
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader = os.Stdin
+	if file != "-" {
+		dumpFile, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer dumpFile.Close()
+		reader = dumpFile
+	}
+
+	return cmd.ExecuteWithStdin(reader)
+}
@@ -0,0 +1,192 @@
+package actions
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"webup/pliz/config"
+	"webup/pliz/domain"
+	"webup/pliz/domain/domaintest"
+)
+
+// inTempDir runs the test in a new directory containing the files
+func inTempDir(t *testing.T, files map[string]string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(previous)
+	})
+
+	for name, content := range files {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// captureStdout returns what is written to os.Stdout by run
+func captureStdout(t *testing.T, run func()) string {
+	t.Helper()
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := os.Stdout
+	os.Stdout = writer
+
+	output := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(reader)
+		output <- string(data)
+	}()
+
+	defer func() {
+		os.Stdout = previous
+	}()
+	run()
+	writer.Close()
+
+	return <-output
+}
+
+// stubDatabase answers the queries finding the running container of the db service
+func stubDatabase(runner *domaintest.RecordingRunner, service string, config string) {
+	runner.Stub([]string{"docker", "compose", "ps", "-q", service}, service+"-id", 0)
+	runner.Stub([]string{"docker", "inspect", "--format", "{{json .Config}}", service + "-id"}, config, 0)
+}
+
+func TestDbDumpToStdout(t *testing.T) {
+	// the Compose file of the env is missing: a warning is printed
+	inTempDir(t, map[string]string{"pliz.yml": "version: 3\n"})
+	if err := config.Load("staging"); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := domaintest.Install(t)
+	stubDatabase(runner, "db", `{"Env":["MYSQL_DATABASE=app","MYSQL_ROOT_PASSWORD=secret"],"Image":"mysql:8"}`)
+	runner.Stub([]string{"docker", "exec", "-i", "-e", "MYSQL_PWD", "db-id", "mysqldump"}, "-- dump of app\n", 0)
+
+	var err error
+	output := captureStdout(t, func() {
+		err = DbDumpActionHandler(domain.ExecutionContext{Env: "staging"}, "db", "", "", true)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if output != "-- dump of app\n" {
+		t.Errorf("expected only the dump on stdout, got %q", output)
+	}
+	runner.AssertCommands(t,
+		[]string{"docker", "compose", "ps", "-q", "db"},
+		[]string{"docker", "inspect", "--format", "{{json .Config}}", "db-id"},
+		[]string{"docker", "exec", "-i", "-e", "MYSQL_PWD", "db-id", "mysqldump", "--user=root", "app"},
+	)
+}
+
+func TestResolveDefaultDatabase(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string // of the container
+		configured []string
+		expected   string
+		err        string
+	}{
+		{
+			name:       "configured database",
+			config:     `{"Env":["MYSQL_DATABASE=app"],"Image":"mysql:8"}`,
+			configured: []string{"shop", "blog"},
+			expected:   "shop",
+		},
+		{
+			name:     "MySQL database of the container",
+			config:   `{"Env":["MYSQL_DATABASE=app","MYSQL_ROOT_PASSWORD=secret"],"Image":"mysql:8"}`,
+			expected: "app",
+		},
+		{
+			name:     "MariaDB database of the container",
+			config:   `{"Env":["MYSQL_DATABASE=app"],"Image":"mariadb:11"}`,
+			expected: "app",
+		},
+		{
+			name:     "PostgreSQL database of the container",
+			config:   `{"Env":["POSTGRES_DB=app","POSTGRES_USER=user"],"Image":"postgres:16"}`,
+			expected: "app",
+		},
+		{
+			name:     "PostgreSQL database of the user",
+			config:   `{"Env":["POSTGRES_USER=user"],"Image":"postgres:16"}`,
+			expected: "user",
+		},
+		{
+			name:     "PostgreSQL default database",
+			config:   `{"Env":[],"Image":"postgres:16"}`,
+			expected: "postgres",
+		},
+		{
+			name:   "MySQL without database",
+			config: `{"Env":["MYSQL_ROOT_PASSWORD=secret"],"Image":"mysql:8"}`,
+			err:    "Unable to find the default database of the service 'db'",
+		},
+		{
+			name:   "MySQL with an empty database",
+			config: `{"Env":["MYSQL_DATABASE="],"Image":"mysql:8"}`,
+			err:    "Unable to find the default database of the service 'db'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runner := domaintest.Install(t)
+			stubDatabase(runner, "db", test.config)
+
+			db, err := findDatabase(domain.ExecutionContext{}, "db", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			name, err := db.resolveDefaultDatabase(test.configured)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected an error containing '%s', got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if name != test.expected {
+				t.Errorf("expected '%s', got '%s'", test.expected, name)
+			}
+		})
+	}
+}
+
+func TestDbDumpWithoutDefaultDatabase(t *testing.T) {
+	inTempDir(t, map[string]string{"pliz.yml": "version: 3\n"})
+	if err := config.Load("dump-test"); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := domaintest.Install(t)
+	stubDatabase(runner, "db", `{"Env":["MYSQL_ROOT_PASSWORD=secret"],"Image":"mysql:8"}`)
+
+	err := DbDumpActionHandler(domain.ExecutionContext{}, "db", "", "", false)
+	if err == nil || !strings.Contains(err.Error(), "Unable to find the default database") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+	// nothing is dumped
+	if commands := runner.Commands(); len(commands) != 2 {
+		t.Errorf("unexpected commands: %v", commands)
+	}
+}
@@ -0,0 +1,129 @@
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+	"webup/pliz/domain"
+)
+
+const (
+	manifestFilename = "manifest.json" // first entry of the archives
+	manifestVersion  = 2
+)
+
+// backupManifest describes the content of a backup archive
+type backupManifest struct {
+	Version     int                `json:"version"`
+	PlizVersion string             `json:"pliz_version"`
+	Project     string             `json:"project"`
+	Env         string             `json:"env"`
+	CreatedAt   time.Time          `json:"created_at"`
+	Databases   []manifestDatabase `json:"databases"`
+	Files       []manifestFile     `json:"files,omitempty"` // all the entries of the archive (config files, files and dumps), in checksums.json since the version 2
+}
+
+type manifestDatabase struct {
+	Service string         `json:"service"`
+	Type    string         `json:"type"`
+	Image   string         `json:"image"`
+	Version string         `json:"version,omitempty"` // printed by the server of the db
+	Dumps   []manifestDump `json:"dumps"`
+}
+
+type manifestDump struct {
+	Path     string `json:"path"`     // entry of the archive (e.g. 'databases/db/app.sql')
+	Database string `json:"database"` // empty if the dump contains all the databases
+}
+
+type manifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func newBackupManifest(ctx domain.ExecutionContext) backupManifest {
+	return backupManifest{
+		Version:     manifestVersion,
+		PlizVersion: domain.Version,
+		Project:     projectName(),
+		Env:         ctx.Env,
+		CreatedAt:   time.Now().UTC(),
+		Databases:   []manifestDatabase{},
+	}
+}
+
+// projectName returns the name of the Compose project (the name of the current directory by default)
+func projectName() string {
+	if name := os.Getenv("COMPOSE_PROJECT_NAME"); name != "" {
+		return name
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(dir)
+}
+
+// file returns the description of the entry of the archive (nil if not found)
+func (m *backupManifest) file(path string) *manifestFile {
+	for i := range m.Files {
+		if m.Files[i].Path == path {
+			return &m.Files[i]
+		}
+	}
+	return nil
+}
+
+// dump returns the db and the dump stored in the entry of the archive (nil if not found)
+func (m *backupManifest) dump(path string) (*manifestDatabase, *manifestDump) {
+	for i := range m.Databases {
+		for j := range m.Databases[i].Dumps {
+			if m.Databases[i].Dumps[j].Path == path {
+				return &m.Databases[i], &m.Databases[i].Dumps[j]
+			}
+		}
+	}
+	return nil, nil
+}
+
+// checksumProblems compares the checksums computed while reading the archive with the ones of the manifest
+func (m *backupManifest) checksumProblems(checksums map[string]string) []string {
+	problems := []string{}
+	for _, file := range m.Files {
+		sum, ok := checksums[file.Path]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s is missing", file.Path))
+		} else if sum != file.SHA256 {
+			problems = append(problems, fmt.Sprintf("Invalid checksum for %s", file.Path))
+		}
+	}
+
+	paths := make([]string, 0, len(checksums))
+	for path := range checksums {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if m.file(path) == nil {
+			problems = append(problems, fmt.Sprintf("%s is not described in the manifest of the backup", path))
+		}
+	}
+
+	return problems
+}
+
+func readManifest(reader io.Reader) (*backupManifest, error) {
+	var manifest backupManifest
+	if err := json.NewDecoder(reader).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("Unable to read the manifest of the backup: %v", err)
+	}
+	if manifest.Version > manifestVersion {
+		return nil, fmt.Errorf("The manifest of the backup (version %d) requires a newer version of pliz", manifest.Version)
+	}
+	return &manifest, nil
+}
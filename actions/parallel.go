@@ -0,0 +1,214 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"webup/pliz/config"
+	"webup/pliz/domain"
+	"webup/pliz/utils"
+
+	"github.com/fatih/color"
+)
+
+type taskStatus string
+
+const (
+	taskExecuted  taskStatus = "executed"
+	taskWouldRun  taskStatus = "would run" // executed in dry-run mode
+	taskUpToDate  taskStatus = "up to date"
+	taskSkipped   taskStatus = "skipped"
+	taskFailed    taskStatus = "failed"
+	taskCancelled taskStatus = "cancelled"
+)
+
+type taskResult struct {
+	id       domain.TaskID
+	status   taskStatus
+	duration time.Duration
+	err      error
+}
+
+// the colors used to prefix the output of the tasks
+var taskColors = []color.Attribute{color.FgCyan, color.FgMagenta, color.FgBlue, color.FgGreen, color.FgYellow, color.FgHiCyan, color.FgHiMagenta, color.FgHiBlue}
+
+// ParallelTasksActionHandler executes the tasks using up to 'jobs' concurrent tasks.
+// A task is started once all its dependencies are done; it is cancelled if one of them failed.
+// ids must contain the dependencies of each task (see domain.TaskGraph.ExecutionOrder).
+func ParallelTasksActionHandler(ids []domain.TaskID, skipped []string, forced bool, jobs int, ctx domain.ExecutionContext) error {
+	results, err := scheduleTasks(ids, config.Get().Tasks, config.Get().TaskGraph, skipped, forced, jobs, ctx)
+	if err != nil {
+		return err
+	}
+
+	// summary
+	fmt.Printf("\n")
+	failures := 0
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(table, "  %s\t%s\t%s\n", "Task", "Status", "Duration")
+	for _, id := range ids {
+		result := results[id]
+
+		status := string(result.status)
+		switch result.status {
+		case taskExecuted, taskWouldRun:
+			status = color.GreenString(status)
+		case taskFailed, taskCancelled:
+			status = color.RedString(status)
+			failures++
+		default:
+			status = color.YellowString(status)
+		}
+
+		duration := "-"
+		if result.duration > 0 {
+			duration = result.duration.Round(100 * time.Millisecond).String()
+		}
+
+		fmt.Fprintf(table, "  %s\t%s\t%s\n", id, status, duration)
+	}
+	table.Flush()
+
+	if failures > 0 {
+		for _, id := range ids {
+			if results[id].err != nil {
+				return results[id].err
+			}
+		}
+		return fmt.Errorf("%d task(s) failed", failures)
+	}
+
+	return nil
+}
+
+// scheduleTasks executes the tasks (see ParallelTasksActionHandler) and returns their results
+func scheduleTasks(ids []domain.TaskID, tasks map[domain.TaskID]domain.Task, graph domain.TaskGraph, skipped []string, forced bool, jobs int, ctx domain.ExecutionContext) (map[domain.TaskID]taskResult, error) {
+	// prepare the prefix of each task, aligned on the longest name
+	width := 0
+	for _, id := range ids {
+		if len(id) > width {
+			width = len(id)
+		}
+	}
+	prefixes := map[domain.TaskID]string{}
+	for i, id := range ids {
+		prefixes[id] = color.New(taskColors[i%len(taskColors)]).SprintfFunc()("%-*s |", width, id) + " "
+	}
+
+	var outputMutex sync.Mutex
+	results := map[domain.TaskID]taskResult{}
+	started := map[domain.TaskID]bool{}
+	done := make(chan taskResult)
+	running := 0
+
+	for len(results) < len(ids) {
+		progress := false
+
+		for _, id := range ids {
+			if started[id] {
+				continue
+			}
+
+			if isSkipped(id, skipped) {
+				started[id] = true
+				results[id] = taskResult{id: id, status: taskSkipped}
+				printTaskStatus(prefixes[id], color.YellowString("skipped"), &outputMutex)
+				progress = true
+				continue
+			}
+
+			// wait for the dependencies
+			ready := true
+			failed := false
+			for _, dependency := range graph[id] {
+				result, ok := results[dependency]
+				if !ok {
+					ready = false
+				} else if result.status == taskFailed || result.status == taskCancelled {
+					failed = true
+				}
+			}
+
+			if failed {
+				started[id] = true
+				results[id] = taskResult{id: id, status: taskCancelled}
+				printTaskStatus(prefixes[id], color.RedString("cancelled (a dependency failed)"), &outputMutex)
+				progress = true
+				continue
+			}
+
+			if !ready || running >= jobs {
+				continue
+			}
+
+			task := tasks[id]
+			// disable the execution check if the installation is forced
+			if forced {
+				task.ExecutionCheck = nil
+			}
+
+			started[id] = true
+			running++
+			progress = true
+
+			go func(task domain.Task, prefix string) {
+				done <- executeTaskWithPrefix(task, prefix, &outputMutex, ctx)
+			}(task, prefixes[id])
+		}
+
+		if running == 0 {
+			if !progress {
+				return results, fmt.Errorf("Unable to schedule the remaining tasks")
+			}
+			continue
+		}
+
+		result := <-done
+		results[result.id] = result
+		running--
+	}
+
+	return results, nil
+}
+
+func executeTaskWithPrefix(task domain.Task, prefix string, outputMutex *sync.Mutex, ctx domain.ExecutionContext) taskResult {
+	stdout := utils.NewPrefixWriter(os.Stdout, prefix, outputMutex)
+	stderr := utils.NewPrefixWriter(os.Stderr, prefix, outputMutex)
+
+	start := time.Now()
+	executed, err := task.Execute(domain.TaskExecutionContext{ExecutionContext: ctx, Stdout: stdout, Stderr: stderr})
+	duration := time.Since(start)
+
+	stdout.Flush()
+	stderr.Flush()
+
+	result := taskResult{id: task.Name, status: taskExecuted, duration: duration, err: err}
+	if err != nil {
+		result.status = taskFailed
+	} else if !executed {
+		result.status = taskUpToDate
+		result.duration = 0
+	} else if domain.IsDryRun() {
+		result.status = taskWouldRun
+		result.duration = 0
+	}
+
+	return result
+}
+
+// printTaskStatus prints the status of a task which is not executed, without interleaving with the output of the running tasks
+func printTaskStatus(prefix string, status string, outputMutex *sync.Mutex) {
+	stdout := utils.NewPrefixWriter(os.Stdout, prefix, outputMutex)
+	fmt.Fprintf(stdout, "%s\n", status)
+}
+
+func isSkipped(id domain.TaskID, skipped []string) bool {
+	for _, taskName := range skipped {
+		if taskName == string(id) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package actions
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"webup/pliz/domain"
+	"webup/pliz/domain/domaintest"
+)
+
+// hostTasks returns tasks run on the host, whose command is their name
+func hostTasks(dependencies map[domain.TaskID][]domain.TaskID) (map[domain.TaskID]domain.Task, domain.TaskGraph) {
+	tasks := map[domain.TaskID]domain.Task{}
+	graph := domain.TaskGraph{}
+	for id, taskDependencies := range dependencies {
+		tasks[id] = domain.Task{Name: id, CommandArgs: domain.CommandArgs{string(id)}, Dependencies: taskDependencies}
+		graph[id] = taskDependencies
+	}
+	return tasks, graph
+}
+
+func TestScheduleTasks(t *testing.T) {
+	tests := []struct {
+		name         string
+		dependencies map[domain.TaskID][]domain.TaskID
+		ids          []domain.TaskID
+		skipped      []string
+		failing      []string
+		expected     map[domain.TaskID]taskStatus
+		commands     [][]string // with a single job
+	}{
+		{
+			name:         "dependencies executed first",
+			dependencies: map[domain.TaskID][]domain.TaskID{"gulp": {"npm"}, "npm": nil, "composer": nil},
+			ids:          []domain.TaskID{"npm", "gulp", "composer"},
+			expected:     map[domain.TaskID]taskStatus{"npm": taskExecuted, "gulp": taskExecuted, "composer": taskExecuted},
+			commands:     [][]string{{"npm"}, {"gulp"}, {"composer"}},
+		},
+		{
+			name:         "task waiting for its dependency",
+			dependencies: map[domain.TaskID][]domain.TaskID{"gulp": {"npm"}, "npm": nil},
+			ids:          []domain.TaskID{"gulp", "npm"},
+			expected:     map[domain.TaskID]taskStatus{"npm": taskExecuted, "gulp": taskExecuted},
+			commands:     [][]string{{"npm"}, {"gulp"}},
+		},
+		{
+			name:         "failed dependency",
+			dependencies: map[domain.TaskID][]domain.TaskID{"deploy": {"gulp"}, "gulp": {"npm"}, "npm": nil, "composer": nil},
+			ids:          []domain.TaskID{"npm", "gulp", "deploy", "composer"},
+			failing:      []string{"npm"},
+			expected:     map[domain.TaskID]taskStatus{"npm": taskFailed, "gulp": taskCancelled, "deploy": taskCancelled, "composer": taskExecuted},
+			commands:     [][]string{{"npm"}, {"composer"}},
+		},
+		{
+			name:         "skipped dependency",
+			dependencies: map[domain.TaskID][]domain.TaskID{"gulp": {"npm"}, "npm": nil},
+			ids:          []domain.TaskID{"npm", "gulp"},
+			skipped:      []string{"npm"},
+			expected:     map[domain.TaskID]taskStatus{"npm": taskSkipped, "gulp": taskExecuted},
+			commands:     [][]string{{"gulp"}},
+		},
+	}
+
+	for _, test := range tests {
+		for _, jobs := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%s (%d jobs)", test.name, jobs), func(t *testing.T) {
+				runner := domaintest.Install(t)
+				for _, command := range test.failing {
+					runner.Stub([]string{command}, "", 2)
+				}
+				tasks, graph := hostTasks(test.dependencies)
+
+				results, err := scheduleTasks(test.ids, tasks, graph, test.skipped, false, jobs, domain.ExecutionContext{})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				for id, status := range test.expected {
+					if results[id].status != status {
+						t.Errorf("status of '%s': expected '%s', got '%s'", id, status, results[id].status)
+					}
+					if status == taskFailed && domain.ExitCode(results[id].err) != 2 {
+						t.Errorf("exit code of '%s': expected 2, got %d (%v)", id, domain.ExitCode(results[id].err), results[id].err)
+					}
+				}
+				if jobs == 1 {
+					runner.AssertCommands(t, test.commands...)
+				} else if len(runner.Commands()) != len(test.commands) {
+					t.Errorf("expected %d commands, got %d", len(test.commands), len(runner.Commands()))
+				}
+			})
+		}
+	}
+}
+
+func TestScheduleTasksWithMissingDependency(t *testing.T) {
+	domaintest.Install(t)
+	tasks, graph := hostTasks(map[domain.TaskID][]domain.TaskID{"gulp": {"npm"}, "npm": nil})
+
+	// the dependencies must be in the list (see domain.TaskGraph.ExecutionOrder)
+	_, err := scheduleTasks([]domain.TaskID{"gulp"}, tasks, graph, nil, false, 2, domain.ExecutionContext{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+// concurrencyRunner records the maximum number of commands running at the same time
+type concurrencyRunner struct {
+	mu      sync.Mutex
+	running int
+	max     int
+}
+
+func (r *concurrencyRunner) Run(c domain.Command, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	r.mu.Lock()
+	r.running++
+	if r.running > r.max {
+		r.max = r.running
+	}
+	r.mu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+
+	r.mu.Lock()
+	r.running--
+	r.mu.Unlock()
+	return nil
+}
+
+func TestScheduleTasksJobs(t *testing.T) {
+	dependencies := map[domain.TaskID][]domain.TaskID{"a": nil, "b": nil, "c": nil, "d": nil, "e": nil}
+	ids := []domain.TaskID{"a", "b", "c", "d", "e"}
+
+	for _, jobs := range []int{1, 2, 5} {
+		runner := &concurrencyRunner{}
+		previous := domain.SetRunner(runner)
+
+		tasks, graph := hostTasks(dependencies)
+		_, err := scheduleTasks(ids, tasks, graph, nil, false, jobs, domain.ExecutionContext{})
+		domain.SetRunner(previous)
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if runner.max != jobs {
+			t.Errorf("%d jobs: %d tasks executed concurrently", jobs, runner.max)
+		}
+	}
+}
+
+func TestScheduleTasksDryRun(t *testing.T) {
+	runner := domaintest.Install(t)
+	domain.SetDryRun(true)
+	defer domain.SetDryRun(false)
+	tasks, graph := hostTasks(map[domain.TaskID][]domain.TaskID{"gulp": {"npm"}, "npm": nil, "composer": nil})
+
+	results, err := scheduleTasks([]domain.TaskID{"npm", "gulp", "composer"}, tasks, graph, []string{"composer"}, false, 2, domain.ExecutionContext{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[domain.TaskID]taskStatus{"npm": taskWouldRun, "gulp": taskWouldRun, "composer": taskSkipped}
+	for id, status := range expected {
+		if results[id].status != status {
+			t.Errorf("status of '%s': expected '%s', got '%s'", id, status, results[id].status)
+		}
+	}
+	runner.AssertCommands(t)
+}
+
+func TestScheduleTasksStatusLines(t *testing.T) {
+	runner := domaintest.Install(t)
+	runner.Stub([]string{"npm"}, "", 1)
+	tasks, graph := hostTasks(map[domain.TaskID][]domain.TaskID{"gulp": {"npm"}, "npm": nil, "composer": nil})
+
+	output := captureStdout(t, func() {
+		scheduleTasks([]domain.TaskID{"npm", "gulp", "composer"}, tasks, graph, []string{"composer"}, false, 2, domain.ExecutionContext{})
+	})
+
+	// written with the prefix of the task, as complete lines
+	for _, line := range []string{"gulp     | cancelled (a dependency failed)\n", "composer | skipped\n"} {
+		if !strings.Contains(output, line) {
+			t.Errorf("expected the line %q in the output:\n%s", line, output)
+		}
+	}
+}
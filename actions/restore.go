@@ -2,32 +2,30 @@ package actions
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/Songmu/prompter"
 	"github.com/fatih/color"
 
 	"webup/pliz/config"
 	"webup/pliz/domain"
-	"webup/pliz/utils"
 )
 
 // RestoreActionHandler handle the action for 'pliz restore'
-func RestoreActionHandler(ctx domain.ExecutionContext, file string, restoreConfigFilesOpt *bool, restoreFilesOpt *bool, restoreDBOpt *bool, key *string, verbose bool) {
+func RestoreActionHandler(ctx domain.ExecutionContext, file string, restoreConfigFilesOpt *bool, restoreFilesOpt *bool, restoreDBOpt *bool, key *string, identity *string, verbose bool) error {
 
 	isQuiet := !(restoreConfigFilesOpt == nil && restoreFilesOpt == nil && restoreDBOpt == nil)
 
-	if ctx.IsProd() && !isQuiet {
-		ok := prompter.YN("You're in production. Are you sure you want to continue?", false)
+	if ctx.IsProtected() && !isQuiet {
+		ok := prompter.YN(fmt.Sprintf("You're in the protected environment '%s'. Are you sure you want to continue?", ctx.Env), false)
 		if !ok {
-			return
+			return nil
 		}
 	}
 
@@ -58,99 +56,79 @@ func RestoreActionHandler(ctx domain.ExecutionContext, file string, restoreConfi
 
 	fmt.Printf("\n\n")
 
-	dpath, dfile := path.Split(file)
-	isEncrypted := false
-	encryptedFile := file
-	decryptedFile := ""
-	if len(dfile) > 4 {
-		encryptedExtension := dfile[len(dfile)-4:]
-		if encryptedExtension != ".enc" && key != nil && *key != "" {
-			fmt.Printf(" %s This is not a .enc file, skip deciphering\n", color.RedString("✗"))
-		}
-		isEncrypted = encryptedExtension == ".enc" && key != nil && *key != ""
-		decryptedFile = dpath + "." + dfile[:len(dfile)-4]
-	}
-
-	// decrypt in an hidden file
-	if isEncrypted {
-		err := decrypt(encryptedFile, decryptedFile, key)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-
-		file = decryptedFile
+	if ((key != nil && *key != "") || (identity != nil && *identity != "")) && !isEncryptedBackup(file) {
+		fmt.Printf(" %s This is not a .enc file, skip deciphering\n", color.RedString("✗"))
 	}
 
-	err := untar(ctx, file, configFilesRestoration, filesRestoration, dbRestoration, verbose)
+	// the whole archive is read once before restoring anything: the HMAC of an encrypted backup
+	// and the checksums are only checked at the end of the stream.
+	// The same file descriptor is read again to restore it: a V1 backup isn't authenticated before its end,
+	// the file must not be replaced between the two readings.
+	infile, err := os.Open(file)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
+	defer infile.Close()
 
-	// remove decrypted file
-	if isEncrypted {
-		err = os.Remove(decryptedFile)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
+	fmt.Printf(" → Verifying %s\n", file)
+	if err := verifyBackup(infile, file, key, identity); err != nil {
+		return err
 	}
-
-	fmt.Printf("\n %s Done\n", color.GreenString("✓"))
-}
-
-func decrypt(encryptedFile string, decryptedFile string, key *string) error {
-	infile, err := os.Open(encryptedFile)
-	if err != nil {
-		log.Fatal(err)
+	if _, err := infile.Seek(0, io.SeekStart); err != nil {
+		return err
 	}
-	outfile, err := os.OpenFile(decryptedFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+
+	// the decrypted archive is never written to the disk
+	reader, err := decryptBackup(infile, file, key, identity)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer reader.Close()
 
-	err = utils.Decrypt(infile, outfile, []byte(*key))
-	infile.Close()
-	outfile.Close()
-
+	err = untar(ctx, reader, configFilesRestoration, filesRestoration, dbRestoration, verbose)
 	if err != nil {
-		removeDecryptedFile(decryptedFile)
-		return fmt.Errorf("Unable to decrypt the file %s\n%s\n", encryptedFile, err)
+		return err
+	}
+	if _, err := io.Copy(ioutil.Discard, reader); err != nil {
+		return fmt.Errorf("Unable to decrypt the end of the file %s (modified during the restoration?), the selected files and databases have already been restored\n%s\n", file, err)
 	}
-	fmt.Printf("\n %s %s decrypted\n", color.GreenString("✓"), encryptedFile)
 
+	fmt.Printf("\n %s Done\n", color.GreenString("✓"))
 	return nil
 }
 
-func untar(ctx domain.ExecutionContext, tarball string, configFilesRestoration bool, filesRestoration bool, dbRestoration bool, verbose bool) error {
-	// open the tarball
-	reader, err := os.Open(tarball)
+// verifyBackup reads the whole archive (from in) to check its HMAC (if encrypted) and its checksums
+func verifyBackup(in io.Reader, file string, key *string, identity *string) error {
+	reader, err := decryptBackup(in, file, key, identity)
 	if err != nil {
 		return err
 	}
 	defer reader.Close()
 
-	// gunzip
-	gzipReader, err := gzip.NewReader(reader)
+	_, err = readBackup(reader, func(header *tar.Header, manifest *backupManifest, entry io.Reader) error {
+		return nil
+	})
 	if err != nil {
-		return err
+		return readBackupError(file, err)
 	}
-	defer gzipReader.Close()
 
-	// read the tarball
-	tarReader := tar.NewReader(gzipReader)
+	if _, err := io.Copy(ioutil.Discard, reader); err != nil {
+		return fmt.Errorf("Unable to decrypt the file %s\n%s\n", file, err)
+	}
+	return nil
+}
 
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return err
-		}
+func untar(ctx domain.ExecutionContext, reader io.Reader, configFilesRestoration bool, filesRestoration bool, dbRestoration bool, verbose bool) error {
+	manifestPrinted := false
 
+	_, err := readBackup(reader, func(header *tar.Header, manifest *backupManifest, entry io.Reader) error {
 		info := header.FileInfo()
 
+		if manifest != nil && verbose && !manifestPrinted {
+			fmt.Printf(" → Backup of '%s' (env '%s') made on %s with pliz %s\n", manifest.Project, manifest.Env, manifest.CreatedAt.Format(time.RFC3339), manifest.PlizVersion)
+			manifestPrinted = true
+		}
+
 		// config
 		if configFilesRestoration {
 			if strings.HasPrefix(header.Name, "config/") {
@@ -158,7 +136,7 @@ func untar(ctx domain.ExecutionContext, tarball string, configFilesRestoration b
 
 				fmt.Printf(" → Restoring %s\n", dest)
 
-				err := copyFile(dest, tarReader, info)
+				err := copyFile(dest, entry, info)
 				if err != nil {
 					return err
 				}
@@ -172,7 +150,7 @@ func untar(ctx domain.ExecutionContext, tarball string, configFilesRestoration b
 
 				fmt.Printf(" → Restoring %s\n", dest)
 
-				err := copyFile(dest, tarReader, info)
+				err := copyFile(dest, entry, info)
 				if err != nil {
 					return err
 				}
@@ -192,22 +170,14 @@ func untar(ctx domain.ExecutionContext, tarball string, configFilesRestoration b
 
 						fmt.Printf("\n → Restoring %s\n", dbBackup.Container)
 
-						// get container id
-						containerID, err := utils.GetContainerID(dbBackup.Container, ctx)
-						if err != nil {
-							fmt.Println("Unable to get the 'db' container id")
-							continue
-						}
-
-						if strings.Contains(comps[1], "mongo") {
-							restoreMongo(containerID, tarReader)
-						} else if strings.Contains(comps[1], ".dump") {
-							restorePostgres(ctx, containerID, comps[1], tarReader, verbose)
-						} else if strings.Contains(comps[1], "sql") {
-							// comps[1] is the filename of the dump (containing the database name, e.g. db.sql)
-							restoreMySQL(ctx, containerID, comps[1], tarReader, dbBackup.AllDatabases, verbose)
+						var restoreErr error
+						if manifest != nil {
+							restoreErr = restoreDatabaseWithManifest(ctx, dbBackup, manifest, header.Name, entry, verbose)
 						} else {
-							fmt.Println("Unrecognized db backup.")
+							restoreErr = restoreDatabase(ctx, dbBackup, comps[1], entry, verbose)
+						}
+						if restoreErr != nil {
+							return fmt.Errorf("Unable to restore the database of '%s': %w", dbBackup.Container, restoreErr)
 						}
 
 					}
@@ -215,15 +185,24 @@ func untar(ctx domain.ExecutionContext, tarball string, configFilesRestoration b
 			}
 		}
 
-	}
+		return nil
+	})
 
-	return nil
+	return err
 }
 
 func copyFile(dest string, source io.Reader, sourceInfo os.FileInfo) error {
 	if sourceInfo.IsDir() {
 		return nil
 	}
+	if domain.IsDryRun() {
+		if _, err := os.Stat(dest); err == nil {
+			domain.PrintDryRun("Overwrite %s", dest)
+		} else {
+			domain.PrintDryRun("Create %s", dest)
+		}
+		return nil
+	}
 	dir := dest
 	if !sourceInfo.IsDir() {
 		dir = filepath.Dir(dest)
@@ -246,68 +225,68 @@ func copyFile(dest string, source io.Reader, sourceInfo os.FileInfo) error {
 	return nil
 }
 
-func restoreMongo(containerID string, mongoArchiveReader *tar.Reader) {
-	cmd := domain.NewCommand([]string{"docker", "exec", "-i", containerID, "mongorestore", "--archive", "--gzip"}, true)
-	cmd.ExecuteWithStdin(mongoArchiveReader)
-}
+// restoreDatabaseWithManifest imports the dump of the archive with the type and the database written in the manifest
+func restoreDatabaseWithManifest(ctx domain.ExecutionContext, dbBackup domain.DatabaseBackupConfig, manifest *backupManifest, entry string, dumpReader io.Reader, verbose bool) error {
+	dbManifest, dump := manifest.dump(entry)
+	if dump == nil {
+		return fmt.Errorf("The dump %s is not described in the manifest of the backup", entry)
+	}
 
-func restoreMySQL(ctx domain.ExecutionContext, containerID string, dumpFilename string, mysqlDumpReader *tar.Reader, allDatabases bool, verbose bool) {
+	db, err := findDatabase(ctx, dbBackup.Container, dbManifest.Type)
+	if err != nil {
+		return err
+	}
 
-	containerConfig, err := utils.GetContainerConfig(containerID, ctx)
+	cmd, err := db.importCommand(filepath.Ext(entry), dump.Database, verbose)
 	if err != nil {
-		return
+		return err
 	}
 
-	password := ""
-	if value, ok := containerConfig.Env["MYSQL_ROOT_PASSWORD"]; ok {
-		password = value
+	return cmd.ExecuteWithStdin(dumpReader)
+}
+
+// restoreDatabase imports the dump of an archive without manifest (the filename gives its format and its database, e.g. db.sql)
+func restoreDatabase(ctx domain.ExecutionContext, dbBackup domain.DatabaseBackupConfig, dumpFilename string, dumpReader io.Reader, verbose bool) error {
+	db, err := findDatabase(ctx, dbBackup.Container, dbBackup.Type)
+	if err != nil {
+		return err
 	}
 
 	ext := filepath.Ext(dumpFilename)
 	database := strings.Replace(dumpFilename, ext, "", 1)
 
-	// backward compatibility, supporting previous filename (dump.sql)
-	if database == "dump" {
-		database = "db"
+	// the type of the db is guessed with the dump if the image is unknown
+	dbType := ""
+	if strings.Contains(dumpFilename, "mongo") {
+		dbType = "mongo"
+		ext = ".archive"
+		database = ""
+	} else if strings.Contains(dumpFilename, ".dump") {
+		dbType = "postgres"
+	} else if strings.Contains(dumpFilename, "sql") {
+		dbType = "mysql"
+		if dbBackup.AllDatabases {
+			database = ""
+		} else if database == "dump" {
+			// backward compatibility, supporting previous filename (dump.sql)
+			database = "db"
+		}
+	} else {
+		fmt.Println("Unrecognized db backup.")
+		return nil
 	}
-
-	cmd := domain.NewCommand([]string{"docker", "exec", "-i", containerID, "mysql", fmt.Sprintf("--password=%s", password), database}, verbose)
-	if allDatabases {
-		cmd = domain.NewCommand([]string{"docker", "exec", "-i", containerID, "mysql", fmt.Sprintf("--password=%s", password)}, verbose)
+	if db.Type == "" {
+		db.Type = dbType
 	}
 
-	cmd.ExecuteWithStdin(mysqlDumpReader)
-}
-
-func restorePostgres(ctx domain.ExecutionContext, containerID string, dumpFilename string, postgresDumpReader *tar.Reader, verbose bool) {
-
-	containerConfig, err := utils.GetContainerConfig(containerID, ctx)
+	cmd, err := db.importCommand(ext, database, verbose)
 	if err != nil {
-		return
-	}
-
-	user := "postgres"
-	if value, ok := containerConfig.Env["POSTGRES_USER"]; ok {
-		user = value
+		return err
 	}
 
-	password := ""
-	if value, ok := containerConfig.Env["POSTGRES_PASSWORD"]; ok {
-		password = value
+	if dbType == "mongo" {
+		cmd.Verbose = true
 	}
 
-	ext := filepath.Ext(dumpFilename)
-	database := strings.Replace(dumpFilename, ext, "", 1)
-
-	cmd := domain.NewCommand([]string{"docker", "exec", "-i", "-e", fmt.Sprintf("PGPASSWORD=\"%s\"", password), containerID, "pg_restore", fmt.Sprintf("--username=%s", user), "-d", database, "-c"}, verbose)
-	cmd.ExecuteWithStdin(postgresDumpReader)
-}
-
-func removeDecryptedFile(file string) {
-	if _, err := os.Stat(file); err == nil {
-		removeErr := os.Remove(file)
-		if removeErr != nil {
-			fmt.Println(removeErr)
-		}
-	}
+	return cmd.ExecuteWithStdin(dumpReader)
 }
@@ -0,0 +1,95 @@
+package actions
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"webup/pliz/domain"
+	"webup/pliz/utils"
+)
+
+// writeEncryptedBackup writes the archive of writeBackupFile encrypted for the recipient, and its identity file
+func writeEncryptedBackup(t *testing.T, file string, recipient utils.PrivateKey) {
+	t.Helper()
+
+	writeBackupFile(t, "archive.tar.gz", nil)
+	archive, err := ioutil.ReadFile("archive.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove("archive.tar.gz")
+
+	var encrypted bytes.Buffer
+	if err := utils.EncryptToRecipients(bytes.NewReader(archive), &encrypted, []utils.PublicKey{recipient.Public()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, encrypted.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeIdentityFile(t *testing.T, file string, key utils.PrivateKey) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(file, []byte(key.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreEncryptedBackup(t *testing.T) {
+	recipient, err := utils.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := utils.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		identity utils.PrivateKey
+		tamper   bool
+		message  string // contained in the error, empty if the backup is restored
+	}{
+		{name: "recipient", identity: recipient},
+		{name: "modified backup", identity: recipient, tamper: true, message: "Invalid authentication of an encrypted chunk"},
+		{name: "wrong identity", identity: other, message: "The backup backup.tar.gz.enc is not encrypted for the keys of identity.txt"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inTempDir(t, nil)
+			writeEncryptedBackup(t, "backup.tar.gz.enc", recipient)
+			writeIdentityFile(t, "identity.txt", test.identity)
+			if test.tamper {
+				flipByte(t, "backup.tar.gz.enc")
+			}
+
+			yes, no := true, false
+			identity := "identity.txt"
+			err := RestoreActionHandler(domain.ExecutionContext{}, "backup.tar.gz.enc", &yes, &yes, &no, nil, &identity, false)
+
+			if test.message == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if content, err := ioutil.ReadFile(".env"); err != nil || string(content) != "APP_KEY=secret\n" {
+					t.Errorf("unexpected restored .env: %q (%v)", content, err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Fatalf("expected an error containing '%s', got %v", test.message, err)
+			}
+			for _, file := range []string{".env", "storage"} {
+				if _, err := os.Stat(file); !os.IsNotExist(err) {
+					t.Errorf("%s has been restored", file)
+				}
+			}
+		})
+	}
+}
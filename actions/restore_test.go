@@ -0,0 +1,124 @@
+package actions
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"webup/pliz/domain"
+)
+
+// writeBackupFile writes an archive with a config file and a file, modified by tamper (if not nil)
+func writeBackupFile(t *testing.T, file string, tamper func(archive *archiveWriter)) {
+	t.Helper()
+
+	data := writeTestArchive(t, func(archive *archiveWriter) {
+		if err := archive.addStream("config/.env", strings.NewReader("APP_KEY=secret\n")); err != nil {
+			t.Fatal(err)
+		}
+		if err := archive.addStream("files/storage/app.txt", strings.NewReader("content\n")); err != nil {
+			t.Fatal(err)
+		}
+		if tamper != nil {
+			tamper(archive)
+		}
+	})
+
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// flipByte modifies a byte in the middle of the file
+func flipByte(t *testing.T, file string) {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeBackupWithoutChecksums writes an archive (version 2 of the manifest) truncated before its checksums
+func writeBackupWithoutChecksums(t *testing.T, file string) {
+	t.Helper()
+
+	data := writeTestArchive(t, func(archive *archiveWriter) {
+		archive.addStream("config/.env", strings.NewReader("APP_KEY=secret\n"))
+		archive.addStream("files/storage/app.txt", strings.NewReader("content\n"))
+	})
+	if err := ioutil.WriteFile(file, withoutEntry(t, data, checksumsFilename), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreRefusesCorruptedBackup(t *testing.T) {
+	tests := []struct {
+		name    string
+		write   func(t *testing.T, file string)
+		message string // contained in the error (any error if empty)
+	}{
+		{
+			name: "modified byte",
+			write: func(t *testing.T, file string) {
+				writeBackupFile(t, file, nil)
+				flipByte(t, file)
+			},
+		},
+		{
+			name: "invalid checksum",
+			write: func(t *testing.T, file string) {
+				writeBackupFile(t, file, func(archive *archiveWriter) {
+					archive.checksums[1].SHA256 = strings.Repeat("0", 64)
+				})
+			},
+			message: "Invalid checksum for files/storage/app.txt",
+		},
+		{
+			name:    "missing checksums",
+			write:   writeBackupWithoutChecksums,
+			message: "checksums.json is missing",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inTempDir(t, nil)
+			test.write(t, "backup.tar.gz")
+
+			yes, no := true, false
+			err := RestoreActionHandler(domain.ExecutionContext{}, "backup.tar.gz", &yes, &yes, &no, nil, nil, false)
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Fatalf("expected an error containing '%s', got %v", test.message, err)
+			}
+
+			// nothing is restored
+			for _, file := range []string{".env", "storage"} {
+				if _, err := os.Stat(file); !os.IsNotExist(err) {
+					t.Errorf("%s has been restored", file)
+				}
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	inTempDir(t, nil)
+	writeBackupFile(t, "backup.tar.gz", nil)
+
+	yes, no := true, false
+	if err := RestoreActionHandler(domain.ExecutionContext{}, "backup.tar.gz", &yes, &yes, &no, nil, nil, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for file, expected := range map[string]string{".env": "APP_KEY=secret\n", "storage/app.txt": "content\n"} {
+		content, err := ioutil.ReadFile(file)
+		if err != nil || string(content) != expected {
+			t.Errorf("%s: expected %q, got %q (%v)", file, expected, content, err)
+		}
+	}
+}
@@ -2,19 +2,45 @@ package actions
 
 import (
 	"fmt"
+	"webup/pliz/config"
 	"webup/pliz/domain"
 
 	"github.com/fatih/color"
+	cli "github.com/jawher/mow.cli"
 )
 
-func RunTaskActionHandler(task domain.Task, prod bool) func() {
+// RunTaskActionHandler executes the task with the extra args (i.e. 'pliz run composer -- require foo/bar').
+// The context is read when the task is executed (it's known once the options of the app are parsed).
+func RunTaskActionHandler(task domain.Task, ctx *domain.ExecutionContext, args *[]string) func() {
 	return func() {
 
+		// the dependencies are executed first, only if they are outdated
+		tasks := config.Get().Tasks
+		for _, id := range config.Get().TaskGraph.ExecutionOrder(task.Dependencies...) {
+			dependency := tasks[id]
+
+			fmt.Printf("\n%s %s %s\n", color.CyanString("***"), dependency.Name, color.CyanString("***"))
+			runTask(dependency, domain.TaskExecutionContext{ExecutionContext: *ctx})
+		}
+
+		if len(task.Dependencies) > 0 {
+			fmt.Printf("\n%s %s %s\n", color.CyanString("***"), task.Name, color.CyanString("***"))
+		}
+
 		// disable the execution check for standalone execution
 		task.ExecutionCheck = nil
 
-		if task.Execute(domain.TaskExecutionContext{Prod: prod}) {
-			fmt.Printf("Task '%s' %s.\n", task.Name, color.GreenString("executed"))
-		}
+		runTask(task, domain.TaskExecutionContext{ExecutionContext: *ctx, Args: *args})
+	}
+}
+
+func runTask(task domain.Task, context domain.TaskExecutionContext) {
+	executed, err := task.Execute(context)
+	if err != nil {
+		fmt.Printf("\n%s: %v\n", color.RedString("Error"), err)
+		cli.Exit(domain.ExitCode(err))
+	}
+	if executed && !domain.IsDryRun() {
+		fmt.Printf("Task '%s' %s.\n", task.Name, color.GreenString("executed"))
 	}
 }
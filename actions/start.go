@@ -5,7 +5,7 @@ import (
 	"webup/pliz/domain"
 )
 
-func StartActionHandler(prod bool, startAdditionalContainers bool) {
+func StartActionHandler(ctx domain.ExecutionContext, startAdditionalContainers bool) error {
 
 	args := []string{"up", "-d", config.Get().StartupContainer}
 
@@ -13,6 +13,6 @@ func StartActionHandler(prod bool, startAdditionalContainers bool) {
 		args = append(args, config.Get().AdditionalStartupContainers...)
 	}
 
-	cmd := domain.NewComposeCommand(args, prod)
-	cmd.Execute()
+	cmd := domain.NewComposeCommand(args, ctx)
+	return cmd.Execute()
 }
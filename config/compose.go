@@ -0,0 +1,269 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"webup/pliz/domain"
+
+	"gopkg.in/yaml.v3"
+)
+
+// serviceReference is a Compose service used by the config
+type serviceReference struct {
+	Service  string
+	Key      string // path of the key in the config (e.g. 'tasks.install.container')
+	Location string // 'file:line:column' of the value in the config files, empty for a default value
+}
+
+// composeFiles returns the Compose files used in the environment (as the 'docker compose' commands of pliz)
+func composeFiles(ctx domain.ExecutionContext) []string {
+	files := []string{"docker-compose.yml"}
+
+	// the Compose file of the environment replaces the default override file (if it exists, see domain.NewComposeCommand)
+	override := "docker-compose.override.yml"
+	if ctx.ComposeOverrideFile() != "" {
+		if _, err := os.Stat(ctx.ComposeOverrideFile()); err == nil {
+			override = ctx.ComposeOverrideFile()
+		}
+	}
+	if _, err := os.Stat(override); err == nil {
+		files = append(files, override)
+	}
+
+	return files
+}
+
+// parseComposeServices returns the names of the services defined in the Compose files
+func parseComposeServices(files []string) (map[string]bool, error) {
+	services := map[string]bool{}
+
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var parsed map[string]yaml.Node
+		if err := yaml.Unmarshal(content, &parsed); err != nil {
+			return nil, fmt.Errorf("Unable to parse '%s': %v", file, err)
+		}
+
+		servicesNode, ok := parsed["services"]
+		if !ok {
+			// version 1 of the Compose file format: the services are at the root
+			for name := range parsed {
+				services[name] = true
+			}
+			continue
+		}
+
+		var fileServices map[string]yaml.Node
+		if err := servicesNode.Decode(&fileServices); err != nil {
+			return nil, fmt.Errorf("Unable to parse the services of '%s': %v", file, err)
+		}
+		for name := range fileServices {
+			services[name] = true
+		}
+	}
+
+	return services, nil
+}
+
+// resolveDefaultDatabase backs up the db service when 'backup.databases' is omitted, if the service
+// is defined in the Compose files of the env
+func resolveDefaultDatabase(config *domain.Config, ctx domain.ExecutionContext) error {
+	if !config.BackupConfig.DefaultDatabase {
+		return nil
+	}
+
+	services, err := parseComposeServices(composeFiles(ctx))
+	if err != nil {
+		return err
+	}
+
+	config.BackupConfig.Databases = []domain.DatabaseBackupConfig{}
+	if services[config.Containers.Db] {
+		config.BackupConfig.Databases = append(config.BackupConfig.Databases, domain.DatabaseBackupConfig{Container: config.Containers.Db})
+	}
+	return nil
+}
+
+// findServiceReferences returns the Compose services used by the config (merged with its overlays):
+//   - the containers set in 'containers' (the default ones are checked where they are used)
+//   - the startup containers
+//   - the containers of the tasks which can be executed: the install tasks (with their dependencies) and the tasks declared in the config
+//   - the containers of the backed up databases
+//
+// The location of a value is the one of the last config file setting it.
+func findServiceReferences(config domain.Config, containers map[string]string, sources []configSource) []serviceReference {
+	references := []serviceReference{}
+	add := func(service string, key string, find func(root *yaml.Node) *yaml.Node) {
+		references = append(references, serviceReference{Service: service, Key: key, Location: locate(sources, find)})
+	}
+
+	roles := []string{}
+	for role := range containers {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	for _, role := range roles {
+		add(containers[role], "containers."+role, containerFinder(role))
+	}
+
+	add(config.StartupContainer, "startup_container", func(root *yaml.Node) *yaml.Node {
+		if node := mappingValue(root, "startup_container"); node != nil {
+			return node
+		}
+		return containerFinder("proxy")(root)
+	})
+
+	for _, container := range config.AdditionalStartupContainers {
+		add(container, "additional_startup_containers", func(root *yaml.Node) *yaml.Node {
+			return sequenceItem(mappingValue(root, "additional_startup_containers"), func(item *yaml.Node) bool { return item.Value == container })
+		})
+	}
+
+	used := map[domain.TaskID]bool{}
+	for _, id := range config.TaskGraph.ExecutionOrder(config.InstallTasks...) {
+		used[id] = true
+	}
+	ids := []string{}
+	for id, task := range config.Tasks {
+		if used[id] || task.Origin != domain.DefaultTaskOrigin {
+			ids = append(ids, string(id))
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		task := config.Tasks[domain.TaskID(id)]
+		// run on the host
+		if task.Container == nil {
+			continue
+		}
+
+		add(*task.Container, fmt.Sprintf("tasks.%s.container", id), func(root *yaml.Node) *yaml.Node {
+			taskSpec := sequenceItem(mappingValue(root, "tasks"), func(item *yaml.Node) bool { return scalarValue(item, "name") == id })
+			if container := mappingValue(taskSpec, "container"); container != nil {
+				return container
+			}
+			// the container of a default task is set in 'containers'
+			switch *task.Container {
+			case config.Containers.App:
+				return containerFinder("app")(root)
+			case config.Containers.Builder:
+				return containerFinder("builder")(root)
+			}
+			return nil
+		})
+	}
+
+	for _, database := range config.BackupConfig.Databases {
+		add(database.Container, "backup.databases.container", func(root *yaml.Node) *yaml.Node {
+			databases := mappingValue(mappingValue(root, "backup"), "databases")
+			spec := sequenceItem(databases, func(item *yaml.Node) bool { return scalarValue(item, "container") == database.Container })
+			return mappingValue(spec, "container")
+		})
+	}
+
+	return references
+}
+
+// containerFinder finds the value of 'containers.<role>'
+func containerFinder(role string) func(root *yaml.Node) *yaml.Node {
+	return func(root *yaml.Node) *yaml.Node {
+		return mappingValue(mappingValue(root, "containers"), role)
+	}
+}
+
+// locate returns the location of the value found in the last config file setting it (empty if not found)
+func locate(sources []configSource, find func(root *yaml.Node) *yaml.Node) string {
+	for i := len(sources) - 1; i >= 0; i-- {
+		if len(sources[i].Document.Content) == 0 {
+			continue
+		}
+		if node := find(sources[i].Document.Content[0]); node != nil {
+			return fmt.Sprintf("%s:%d:%d", sources[i].Filename, node.Line, node.Column)
+		}
+	}
+	return ""
+}
+
+// scalarValue returns the value of the key in a mapping node (empty if not found)
+func scalarValue(node *yaml.Node, key string) string {
+	if value := mappingValue(node, key); value != nil {
+		return value.Value
+	}
+	return ""
+}
+
+// sequenceItem returns the first item of a sequence node matching the predicate (nil if not found)
+func sequenceItem(node *yaml.Node, match func(item *yaml.Node) bool) *yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return nil
+	}
+	for _, item := range node.Content {
+		if match(item) {
+			return item
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the value of the key in a mapping node (nil if not found)
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// checkServices reports every service used by the config (merged with its overlays) which is not defined
+// in the Compose files of the environment
+func checkServices(config domain.Config, containers map[string]string, sources []configSource, ctx domain.ExecutionContext) error {
+	files := composeFiles(ctx)
+
+	services, err := parseComposeServices(files)
+	if err != nil {
+		return err
+	}
+
+	available := []string{}
+	for name := range services {
+		available = append(available, name)
+	}
+	sort.Strings(available)
+
+	// an unknown service is reported once per location (a value of 'containers' may be used by several keys)
+	reported := map[serviceReference]bool{}
+	errors := []string{}
+	for _, reference := range findServiceReferences(config, containers, sources) {
+		seen := serviceReference{Service: reference.Service, Location: reference.Location}
+		if reference.Location == "" {
+			seen.Key = reference.Key
+		}
+		if services[reference.Service] || reported[seen] {
+			continue
+		}
+		reported[seen] = true
+
+		if reference.Location == "" {
+			errors = append(errors, fmt.Sprintf("%s: unknown service '%s' (default value)", reference.Key, reference.Service))
+		} else {
+			errors = append(errors, fmt.Sprintf("%s: %s: unknown service '%s'", reference.Location, reference.Key, reference.Service))
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("Some services are not defined in %s (available: %s)\n%s", strings.Join(files, ", "), strings.Join(available, ", "), strings.Join(errors, "\n"))
+	}
+
+	return nil
+}
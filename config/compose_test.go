@@ -0,0 +1,73 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"webup/pliz/domain"
+)
+
+func TestValidateServices(t *testing.T) {
+	compose := "services:\n  proxy: {}\n  app: {}\n  srcbuild: {}\n  db: {}\n"
+
+	tests := []struct {
+		name   string
+		config string
+		errors []string // lines of the error, nil if the config is valid
+	}{
+		{
+			name:   "valid",
+			config: "version: 3\ntasks:\n  - name: hello\n    container: app\n    command: [echo, hello]\n",
+		},
+		{
+			name:   "task container",
+			config: "version: 3\ntasks:\n  - name: hello\n    container: php\n    command: [echo, hello]\n",
+			errors: []string{"pliz.yml:4:16: tasks.hello.container: unknown service 'php'"},
+		},
+		{
+			name:   "db container",
+			config: "version: 3\ncontainers:\n  db: database\n",
+			errors: []string{"pliz.yml:3:7: containers.db: unknown service 'database'"},
+		},
+		{
+			name: "repeated unknown service",
+			config: "version: 3\nadditional_startup_containers: [php]\ntasks:\n" +
+				"  - name: hello\n    container: php\n    command: [echo, hello]\n" +
+				"  - name: bye\n    container: php\n    command: [echo, bye]\n",
+			errors: []string{
+				"pliz.yml:2:33: additional_startup_containers: unknown service 'php'",
+				"pliz.yml:8:16: tasks.bye.container: unknown service 'php'",
+				"pliz.yml:5:16: tasks.hello.container: unknown service 'php'",
+			},
+		},
+		{
+			name:   "default container used by several tasks",
+			config: "version: 3\ncontainers:\n  app: php\n",
+			errors: []string{"pliz.yml:3:8: containers.app: unknown service 'php'"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inTempDir(t, map[string]string{"pliz.yml": test.config, "docker-compose.yml": compose})
+
+			errs := Validate(domain.ExecutionContext{})
+			if test.errors == nil {
+				if len(errs) > 0 {
+					t.Fatalf("unexpected errors: %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 {
+				t.Fatalf("expected an error, got %v", errs)
+			}
+
+			lines := strings.Split(errs[0].Error(), "\n")
+			if !strings.HasPrefix(lines[0], "Some services are not defined in docker-compose.yml") {
+				t.Errorf("unexpected error: %v", errs[0])
+			}
+			if strings.Join(lines[1:], "\n") != strings.Join(test.errors, "\n") {
+				t.Errorf("expected the errors:\n%s\ngot:\n%s", strings.Join(test.errors, "\n"), strings.Join(lines[1:], "\n"))
+			}
+		})
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"webup/pliz/domain"
 	"webup/pliz/tasks"
+	"webup/pliz/utils"
 
 	"gopkg.in/yaml.v3"
 )
@@ -15,15 +17,53 @@ const (
 )
 
 var loadedConfig *domain.Config
+var loadedEnv string
 
-func Check() error {
+// the files of the loaded config, with their parsed content (used by Check)
+var loadedSources []configSource
+var loadedContainers map[string]string
 
-	if loadedConfig == nil {
-		config, err := parseConfigFile()
+// ErrorList gathers all the errors found in the config
+type ErrorList []error
+
+func (list ErrorList) Error() string {
+	messages := []string{}
+	for _, err := range list {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// CheckEnvName checks that the name of the env can be used in the names of its files (pliz.<env>.yml, docker-compose.<env>.yml)
+func CheckEnvName(env string) error {
+	if strings.ContainsAny(env, "/\\ ") || strings.Contains(env, "..") {
+		return fmt.Errorf("'%s' is not a valid name of environment", env)
+	}
+	return nil
+}
+
+// Load parses the config files of the env (only once per env)
+func Load(env string) error {
+
+	if loadedConfig == nil || loadedEnv != env {
+		config, parsed, sources, err := parseConfigFile(env)
 		if err != nil {
 			return err
 		}
 		loadedConfig = &config
+		loadedEnv = env
+		loadedSources = sources
+		loadedContainers = parsed.Containers
+	}
+
+	return nil
+}
+
+// Check loads the config and checks it against the Compose files of the environment
+func Check(ctx domain.ExecutionContext) error {
+
+	if err := Load(ctx.Env); err != nil {
+		return err
 	}
 
 	if _, err := os.Stat("docker-compose.yml"); os.IsNotExist(err) {
@@ -31,6 +71,17 @@ func Check() error {
 		return err
 	}
 
+	if err := resolveDefaultDatabase(loadedConfig, ctx); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	// the files read by Load are checked (the values depending on the env must not be resolved twice)
+	if err := checkServices(Get(), loadedContainers, loadedSources, ctx); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
 	return nil
 }
 
@@ -39,17 +90,25 @@ func Get() domain.Config {
 }
 
 type parserConfig struct {
-	StartupContainer            string            `yaml:"startup_container"`
-	AdditionalStartupContainers []string          `yaml:"additional_startup_containers"`
-	Containers                  map[string]string `yaml:"containers"`
-	ConfigFiles                 map[string]string `yaml:"config_files"`
-	Tasks                       []TaskSpec        `yaml:"tasks"`
-	InstallTasks                []domain.TaskID   `yaml:"install_tasks"`
-	Checklist                   []string          `yaml:"checklist"`
-	Backup                      BackupSpec        `yaml:"backup"`
+	Version                     int                        `yaml:"version"`
+	StartupContainer            string                     `yaml:"startup_container"`
+	AdditionalStartupContainers []string                   `yaml:"additional_startup_containers"`
+	Containers                  map[string]string          `yaml:"containers"`
+	ConfigFiles                 map[string]string          `yaml:"config_files"`
+	Tasks                       []TaskSpec                 `yaml:"tasks"`
+	InstallTasks                []domain.TaskID            `yaml:"install_tasks"`
+	DefaultTasksCheck           string                     `yaml:"default_tasks_check"`
+	Checklist                   []string                   `yaml:"checklist"`
+	Backup                      BackupSpec                 `yaml:"backup"`
+	Environments                map[string]EnvironmentSpec `yaml:"environments"`
+
+	EnabledTasks interface{} `yaml:"enabled_tasks"` // rev 1 layout, see 'pliz config migrate'
 }
 
+// convertToConfig fills the config. All the errors found are returned (as an ErrorList).
 func (parsed parserConfig) convertToConfig(config *domain.Config) error {
+	errs := ErrorList{}
+
 	// container config
 	containerConfig := domain.ContainerConfig{
 		Proxy:   "proxy",
@@ -66,6 +125,9 @@ func (parsed parserConfig) convertToConfig(config *domain.Config) error {
 	if builderContainerName, ok := parsed.Containers["builder"]; ok {
 		containerConfig.Builder = builderContainerName
 	}
+	if dbContainerName, ok := parsed.Containers["db"]; ok {
+		containerConfig.Db = dbContainerName
+	}
 	config.Containers = containerConfig
 
 	// startup container
@@ -84,13 +146,26 @@ func (parsed parserConfig) convertToConfig(config *domain.Config) error {
 	}
 	config.ConfigFiles = configFiles
 
+	// execution check of the default tasks
+	switch domain.ExecutionCheckMethod(parsed.DefaultTasksCheck) {
+	case "", domain.ModificationDateCheckMethod:
+		config.DefaultTasksCheck = domain.ModificationDateCheckMethod
+	case domain.ContentHashCheckMethod:
+		config.DefaultTasksCheck = domain.ContentHashCheckMethod
+	default:
+		errs = append(errs, fmt.Errorf("default_tasks_check: '%s' is not supported (modification_date or content_hash)", parsed.DefaultTasksCheck))
+		config.DefaultTasksCheck = domain.ModificationDateCheckMethod
+	}
+
 	// will be used to prepare the list of the available tasks (default & custom tasks)
 	tasksByID := map[domain.TaskID]domain.Task{}
 	for _, id := range domain.DefaultTaskNames() {
 		task, err := tasks.CreateTaskWithName(id, *config)
 		if err != nil {
-			return err
+			errs = append(errs, err)
+			continue
 		}
+		task.Origin = domain.DefaultTaskOrigin
 
 		tasksByID[id] = task
 	}
@@ -102,6 +177,7 @@ func (parsed parserConfig) convertToConfig(config *domain.Config) error {
 
 		// check if the task is an overrided default task
 		if task, ok := tasksByID[id]; ok {
+			task.Origin = domain.OverriddenTaskOrigin
 
 			// overrided description (not required)
 			if taskSpec.Description != "" {
@@ -118,11 +194,45 @@ func (parsed parserConfig) convertToConfig(config *domain.Config) error {
 				}
 			}
 
+			// check if the mode is overrided
+			if taskSpec.Mode != "" {
+				mode, err := taskSpec.containerMode()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("Task '%s': %v", taskSpec.Name, err))
+				}
+				task.Mode = mode
+			}
+
 			// check if the command is overrided
 			if len(taskSpec.CommandArgs) > 0 {
 				task.CommandArgs = taskSpec.CommandArgs
-			} else {
-				return fmt.Errorf("Not enough args to execute the command of the task '%s'", taskSpec.Name)
+			} else if !taskSpec.overlayOnly {
+				errs = append(errs, fmt.Errorf("Not enough args to execute the command of the task '%s'", taskSpec.Name))
+			}
+
+			// dependencies
+			if len(taskSpec.DependsOn) > 0 {
+				task.Dependencies = taskSpec.taskDependencies()
+			}
+
+			// execution check
+			if taskSpec.Check != nil {
+				check, err := taskSpec.Check.toExecutionCheck(taskSpec.Name)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("Check of the task '%s': %v", taskSpec.Name, err))
+				}
+				task.ExecutionCheck = check
+			}
+
+			// environment
+			if len(taskSpec.Env) > 0 {
+				task.Env = taskSpec.Env
+			}
+			if taskSpec.WorkingDir != "" {
+				task.WorkingDir = taskSpec.WorkingDir
+			}
+			if taskSpec.User != "" {
+				task.User = taskSpec.User
 			}
 
 			tasksByID[id] = task
@@ -131,26 +241,54 @@ func (parsed parserConfig) convertToConfig(config *domain.Config) error {
 
 			// check if the custom task is valid
 			if err := taskSpec.IsValidForCustomTask(); err != nil {
-				return fmt.Errorf("Custom task error: %v", err)
+				errs = append(errs, fmt.Errorf("Custom task error: %v", err))
+				continue
 			}
 
-			task := domain.Task{Name: id, Description: taskSpec.Description}
+			task := domain.Task{Name: id, Description: taskSpec.Description, Origin: domain.CustomTaskOrigin}
 			// check if the container is specified
 			if taskSpec.Container != "none" {
 				task.Container = &(parsed.Tasks[i].Container)
 			}
+			// mode of execution in the container
+			mode, err := taskSpec.containerMode()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Custom task error: %v", err))
+			}
+			task.Mode = mode
 			// command args
 			task.CommandArgs = taskSpec.CommandArgs
+			// dependencies
+			task.Dependencies = taskSpec.taskDependencies()
+			// execution check
+			if taskSpec.Check != nil {
+				check, err := taskSpec.Check.toExecutionCheck(taskSpec.Name)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("Check of the task '%s': %v", taskSpec.Name, err))
+				}
+				task.ExecutionCheck = check
+			}
+			// environment
+			task.Env = taskSpec.Env
+			task.WorkingDir = taskSpec.WorkingDir
+			task.User = taskSpec.User
 
 			tasksByID[id] = task
 		}
 	}
 	config.Tasks = tasksByID
 
+	// dependencies between tasks
+	taskGraph, err := domain.NewTaskGraph(config.Tasks)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	config.TaskGraph = taskGraph
+
 	// install tasks
 	for _, id := range parsed.InstallTasks {
 		if _, ok := config.Tasks[id]; !ok {
-			return fmt.Errorf("Install tasks: '%s' is not available", id)
+			errs = append(errs, fmt.Errorf("Install tasks: '%s' is not available", id))
 		}
 	}
 	config.InstallTasks = parsed.InstallTasks
@@ -170,34 +308,168 @@ func (parsed parserConfig) convertToConfig(config *domain.Config) error {
 		}
 		backupConfig.Databases = append(backupConfig.Databases, dbBackupConfig)
 	}
+	// without 'backup.databases', the db service is backed up (see resolveDefaultDatabase)
+	backupConfig.DefaultDatabase = parsed.Backup.Databases == nil
+	for _, recipient := range parsed.Backup.Recipients {
+		if _, err := utils.ParsePublicKey(recipient); err != nil {
+			errs = append(errs, fmt.Errorf("Backup recipients: %v", err))
+			continue
+		}
+		backupConfig.Recipients = append(backupConfig.Recipients, recipient)
+	}
 	config.BackupConfig = backupConfig
 
+	// environments
+	config.Environments = map[string]domain.Environment{}
+	for name, environment := range parsed.Environments {
+		if name == "" || CheckEnvName(name) != nil {
+			errs = append(errs, fmt.Errorf("Environments: '%s' is not a valid name", name))
+			continue
+		}
+		config.Environments[name] = domain.Environment{Protected: environment.Protected}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
 	return nil
 }
 
-func parseConfigFile() (domain.Config, error) {
+// parseConfigFile returns the config of the env, with its parsed files
+func parseConfigFile(env string) (domain.Config, parserConfig, []configSource, error) {
 
 	config := domain.Config{}
 
-	configFile, err := ioutil.ReadFile(defaultFilename)
+	parsed, sources, err := readConfigFile(env)
 	if err != nil {
-		fmt.Println("Unable to find a config file 'pliz.yml' in the current directory")
-		return config, err
+		fmt.Println(err)
+		return config, parsed, nil, err
 	}
 
-	var parsed parserConfig
-	err = yaml.Unmarshal(configFile, &parsed)
+	err = parsed.convertToConfig(&config)
 	if err != nil {
-		fmt.Println("Unable to parse the config file. Check 'pliz.yml' syntax.")
 		fmt.Println(err)
-		return config, err
+		return config, parsed, nil, err
 	}
 
-	err = parsed.convertToConfig(&config)
+	return config, parsed, sources, nil
+}
+
+// readConfigFile reads and parses the config file, then merges the overlays of the env on top of it.
+// The variables ('${VAR}') are expanded in the values (since the version 3). The parsed files are returned too.
+func readConfigFile(env string) (parserConfig, []configSource, error) {
+
+	var parsed parserConfig
+	sources := []configSource{}
+
+	if err := CheckEnvName(env); err != nil {
+		return parsed, nil, err
+	}
+
+	configFile, err := ioutil.ReadFile(defaultFilename)
 	if err != nil {
-		fmt.Println(err)
-		return config, err
+		return parsed, nil, fmt.Errorf("Unable to find a config file 'pliz.yml' in the current directory")
+	}
+	sources = append(sources, configSource{Filename: defaultFilename, Content: configFile})
+
+	for _, filename := range overlayFilenames(env) {
+		content, err := ioutil.ReadFile(filename)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return parsed, nil, err
+		}
+		sources = append(sources, configSource{Filename: filename, Content: content})
+	}
+
+	variables, err := interpolationVariables(env)
+	if err != nil {
+		return parsed, nil, err
+	}
+
+	baseVersion := 0
+	for i := range sources {
+		source := &sources[i]
+
+		err = yaml.Unmarshal(source.Content, &source.Document)
+		if err != nil {
+			return parsed, nil, fmt.Errorf("Unable to parse the config file. Check '%s' syntax.\n%v", source.Filename, err)
+		}
+
+		// the variables are expanded since the version 3 (the version of pliz.yml is used by the overlays without one)
+		var declared struct {
+			Version int `yaml:"version"`
+		}
+		if len(source.Document.Content) > 0 {
+			if err := source.Document.Decode(&declared); err != nil {
+				return parsed, nil, fmt.Errorf("Unable to parse the config file. Check '%s' syntax.\n%v", source.Filename, err)
+			}
+		}
+		if declared.Version == 0 {
+			declared.Version = baseVersion
+		}
+		if i == 0 {
+			baseVersion = declared.Version
+		}
+		if declared.Version >= interpolationVersion {
+			if err := interpolateNode(&source.Document, variables, source.Filename); err != nil {
+				return parsed, nil, err
+			}
+		}
+
+		var sourceParsed parserConfig
+		if len(source.Document.Content) > 0 {
+			if err := source.Document.Decode(&sourceParsed); err != nil {
+				return parsed, nil, fmt.Errorf("Unable to parse the config file. Check '%s' syntax.\n%v", source.Filename, err)
+			}
+		}
+
+		if err := sourceParsed.checkVersion(); err != nil {
+			return parsed, nil, fmt.Errorf("%s: %v", source.Filename, err)
+		}
+
+		if i == 0 {
+			parsed = sourceParsed
+		} else {
+			parsed = parsed.merge(sourceParsed)
+		}
+	}
+
+	return parsed, sources, nil
+}
+
+// Validate checks the config file and the services used, returning all the errors found (nothing is printed)
+func Validate(ctx domain.ExecutionContext) ErrorList {
+	errs := ErrorList{}
+
+	parsed, sources, err := readConfigFile(ctx.Env)
+	if list, ok := err.(ErrorList); ok {
+		return append(errs, list...)
+	} else if err != nil {
+		return append(errs, err)
+	}
+
+	config := domain.Config{}
+	if err := parsed.convertToConfig(&config); err != nil {
+		if list, ok := err.(ErrorList); ok {
+			errs = append(errs, list...)
+		} else {
+			errs = append(errs, err)
+		}
+	}
+
+	if _, err := os.Stat("docker-compose.yml"); os.IsNotExist(err) {
+		return append(errs, fmt.Errorf("Unable to find a Docker Compose file in the current directory"))
+	}
+
+	if err := resolveDefaultDatabase(&config, ctx); err != nil {
+		return append(errs, err)
+	}
+
+	if err := checkServices(config, parsed.Containers, sources, ctx); err != nil {
+		errs = append(errs, err)
 	}
 
-	return config, nil
+	return errs
 }
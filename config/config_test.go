@@ -0,0 +1,76 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"webup/pliz/domain"
+)
+
+// inTempDir runs the test in a new directory containing the files
+func inTempDir(t *testing.T, files map[string]string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(previous)
+	})
+
+	for name, content := range files {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCheckEnvName(t *testing.T) {
+	tests := []struct {
+		env   string
+		valid bool
+	}{
+		{"", true},
+		{"prod", true},
+		{"pre-prod_2", true},
+		{"v1.2", true},
+		{"../prod", false},
+		{"..", false},
+		{"a..b", false},
+		{"prod/eu", false},
+		{`prod\eu`, false},
+		{"my env", false},
+	}
+
+	for _, test := range tests {
+		if err := CheckEnvName(test.env); (err == nil) != test.valid {
+			t.Errorf("'%s': expected valid = %v, got %v", test.env, test.valid, err)
+		}
+	}
+}
+
+func TestCheckReusesLoadedFiles(t *testing.T) {
+	inTempDir(t, map[string]string{
+		"pliz.yml":           "version: 3\ncontainers:\n  app: ${APP:-app}\n",
+		"docker-compose.yml": "services:\n  proxy: {}\n  app: {}\n  srcbuild: {}\n  db: {}\n",
+	})
+	loadedConfig = nil
+	defer func() { loadedConfig = nil }()
+
+	if err := Load(""); err != nil {
+		t.Fatal(err)
+	}
+
+	// the config files are not read again by Check
+	if err := ioutil.WriteFile("pliz.yml", []byte("version: 3\ncontainers:\n  app: unknown\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Check(domain.ExecutionContext{}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const dotEnvFilename = ".env"
+
+// interpolationVariables returns the variables usable in the config files, by order of priority:
+// the name of the pliz env (PLIZ_ENV), the environment of the process, then the '.env' file of the project
+func interpolationVariables(env string) (map[string]string, error) {
+	variables := map[string]string{}
+
+	content, err := ioutil.ReadFile(dotEnvFilename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Unable to read '%s': %v", dotEnvFilename, err)
+	}
+	for name, value := range parseDotEnv(content) {
+		variables[name] = value
+	}
+
+	for _, variable := range os.Environ() {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) == 2 {
+			variables[parts[0]] = parts[1]
+		}
+	}
+
+	variables["PLIZ_ENV"] = env
+
+	return variables, nil
+}
+
+// parseDotEnv reads the KEY=VALUE lines of a '.env' file (comments, blank lines and invalid lines are ignored)
+func parseDotEnv(content []byte) map[string]string {
+	variables := map[string]string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+
+		// quoted value
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			// inline comment
+			value = strings.TrimSpace(value[:i])
+		}
+
+		variables[name] = value
+	}
+
+	return variables
+}
+
+// interpolateNode expands the variables in all the scalar values of the node (the keys are not expanded).
+// All the errors are returned (as an ErrorList), prefixed by their location in the file.
+func interpolateNode(node *yaml.Node, variables map[string]string, filename string) error {
+	errs := ErrorList{}
+
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		switch node.Kind {
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for _, child := range node.Content {
+				walk(child)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				walk(node.Content[i+1])
+			}
+		case yaml.ScalarNode:
+			value, err := interpolate(node.Value, variables)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s:%d:%d: %v", filename, node.Line, node.Column, err))
+				return
+			}
+			if value != node.Value && node.Style&yaml.TaggedStyle == 0 {
+				// the type of the value is resolved once expanded (i.e. '${DEBUG:-true}' is a bool), unless it's set explicitly
+				node.Tag = ""
+			}
+			node.Value = value
+		}
+	}
+	walk(node)
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// interpolate expands '${VAR}' and '${VAR:-default}' (default used if VAR is unset or empty) in the value.
+// '$$' is replaced by '$', so '$${VAR}' is kept as '${VAR}' (i.e. for a shell command).
+func interpolate(value string, variables map[string]string) (string, error) {
+	if !strings.Contains(value, "$") {
+		return value, nil
+	}
+
+	var result strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 == len(value) {
+			result.WriteByte(value[i])
+			continue
+		}
+
+		switch value[i+1] {
+		case '$':
+			result.WriteByte('$')
+			i++
+		case '{':
+			end := strings.Index(value[i:], "}")
+			if end < 0 {
+				return "", fmt.Errorf("Missing '}' in '%s'", value)
+			}
+			expression := value[i+2 : i+end]
+
+			name := expression
+			defaultValue := ""
+			hasDefault := false
+			if sep := strings.Index(expression, ":-"); sep >= 0 {
+				name = expression[:sep]
+				defaultValue = expression[sep+2:]
+				hasDefault = true
+			}
+			if !isVariableName(name) {
+				return "", fmt.Errorf("Invalid variable '${%s}'", expression)
+			}
+
+			variable, ok := variables[name]
+			switch {
+			case ok && variable != "":
+				result.WriteString(variable)
+			case hasDefault:
+				result.WriteString(defaultValue)
+			case ok:
+				// set but empty
+			default:
+				return "", fmt.Errorf("The variable '%s' is not set (use '${%s:-default}' to give a default value)", name, name)
+			}
+			i += end
+		default:
+			result.WriteByte('$')
+		}
+	}
+
+	return result.String(), nil
+}
+
+func isVariableName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestInterpolate(t *testing.T) {
+	variables := map[string]string{"APP": "php", "EMPTY": "", "PLIZ_ENV": "prod", "_X1": "x"}
+
+	tests := []struct {
+		value    string
+		expected string
+		err      string
+	}{
+		{value: "no variable", expected: "no variable"},
+		{value: "${APP}", expected: "php"},
+		{value: "${APP}-fpm:${PLIZ_ENV}", expected: "php-fpm:prod"},
+		{value: "${_X1}", expected: "x"},
+		{value: "${MISSING:-default}", expected: "default"},
+		{value: "${MISSING:-}", expected: ""},
+		{value: "${EMPTY:-default}", expected: "default"},
+		{value: "${APP:-default}", expected: "php"},
+		{value: "${MISSING:-a b:c}", expected: "a b:c"},
+		{value: "${EMPTY}", expected: ""},
+		{value: "$$", expected: "$"},
+		{value: "$${APP}", expected: "${APP}"},
+		{value: "$$$${APP}", expected: "$${APP}"},
+		{value: "$$${APP}", expected: "$php"},
+		{value: "$APP", expected: "$APP"},
+		{value: "cost: 5$", expected: "cost: 5$"},
+		{value: "${MISSING}", err: "The variable 'MISSING' is not set"},
+		{value: "${APP", err: "Missing '}'"},
+		{value: "${}", err: "Invalid variable '${}'"},
+		{value: "${1APP}", err: "Invalid variable '${1APP}'"},
+		{value: "${A-B}", err: "Invalid variable '${A-B}'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			value, err := interpolate(test.value, variables)
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected the error %q, got %q (%v)", test.err, value, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if value != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, value)
+			}
+		})
+	}
+}
+
+func TestInterpolateNode(t *testing.T) {
+	var document yaml.Node
+	content := "${KEY}: ${APP}\ntasks:\n  - command: [sh, -c, 'echo $${HOME}']\n    container: ${MISSING}\n  - container: ${OTHER}\n"
+	if err := yaml.Unmarshal([]byte(content), &document); err != nil {
+		t.Fatal(err)
+	}
+
+	err := interpolateNode(&document, map[string]string{"APP": "php"}, "pliz.yml")
+
+	// all the errors are reported, with their location
+	list, ok := err.(ErrorList)
+	if !ok || len(list) != 2 {
+		t.Fatalf("expected 2 errors, got %v", err)
+	}
+	if !strings.HasPrefix(list[0].Error(), "pliz.yml:4:16: ") || !strings.HasPrefix(list[1].Error(), "pliz.yml:5:16: ") {
+		t.Errorf("unexpected locations: %v", list)
+	}
+
+	var parsed map[string]interface{}
+	if err := document.Decode(&parsed); err != nil {
+		t.Fatal(err)
+	}
+	// the keys are not expanded
+	if parsed["${KEY}"] != "php" {
+		t.Errorf("unexpected values: %v", parsed)
+	}
+	command := parsed["tasks"].([]interface{})[0].(map[string]interface{})["command"]
+	if !reflect.DeepEqual(command, []interface{}{"sh", "-c", "echo ${HOME}"}) {
+		t.Errorf("unexpected command: %v", command)
+	}
+}
+
+func TestParseDotEnv(t *testing.T) {
+	content := `
+# comment
+APP=php
+export ENV=prod
+QUOTED="a # b"
+SINGLE='c'
+COMMENTED=value # comment
+ SPACES = d
+INVALID
+EMPTY=
+`
+	expected := map[string]string{"APP": "php", "ENV": "prod", "QUOTED": "a # b", "SINGLE": "c", "COMMENTED": "value", "SPACES": "d", "EMPTY": ""}
+
+	if variables := parseDotEnv([]byte(content)); !reflect.DeepEqual(variables, expected) {
+		t.Errorf("expected %v, got %v", expected, variables)
+	}
+}
+
+func TestReadConfigFileInterpolation(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		env      string
+		expected string // startup container
+	}{
+		{
+			name:     "version 3",
+			files:    map[string]string{"pliz.yml": "version: 3\nstartup_container: ${PLIZ_ENV}-proxy"},
+			env:      "prod",
+			expected: "prod-proxy",
+		},
+		{
+			name:     "version 2",
+			files:    map[string]string{"pliz.yml": "version: 2\nstartup_container: ${PLIZ_ENV}-proxy"},
+			env:      "prod",
+			expected: "${PLIZ_ENV}-proxy",
+		},
+		{
+			name:     "overlay without version",
+			files:    map[string]string{"pliz.yml": "version: 3", "pliz.local.yml": "startup_container: $${PLIZ_ENV}"},
+			expected: "${PLIZ_ENV}",
+		},
+		{
+			name:     "overlay of a version 2 config",
+			files:    map[string]string{"pliz.yml": "version: 2", "pliz.local.yml": "startup_container: $${PLIZ_ENV}"},
+			expected: "$${PLIZ_ENV}",
+		},
+		{
+			name:     "overlay of version 3",
+			files:    map[string]string{"pliz.yml": "version: 2", "pliz.local.yml": "version: 3\nstartup_container: ${PLIZ_ENV:-dev}-proxy"},
+			expected: "dev-proxy",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inTempDir(t, test.files)
+
+			parsed, _, err := readConfigFile(test.env)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if parsed.StartupContainer != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, parsed.StartupContainer)
+			}
+		})
+	}
+}
+
+func TestReadConfigFileInterpolatedTypes(t *testing.T) {
+	inTempDir(t, map[string]string{
+		"pliz.yml": "version: 3\n" +
+			"startup_container: ${PROXY:-80}\n" +
+			"environments:\n" +
+			"  prod:\n    protected: ${PROTECTED:-true}\n" +
+			"  staging:\n    protected: ${PROTECTED:-false}\n" +
+			"backup:\n  databases:\n    - container: db\n      no_lock: ${NO_LOCK:-yes}\n",
+		".env": "NO_LOCK=true\n",
+	})
+
+	parsed, _, err := readConfigFile("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if parsed.StartupContainer != "80" {
+		t.Errorf("expected the string '80', got %q", parsed.StartupContainer)
+	}
+	if !parsed.Environments["prod"].Protected || parsed.Environments["staging"].Protected {
+		t.Errorf("unexpected environments: %v", parsed.Environments)
+	}
+	if !parsed.Backup.Databases[0].NoLock {
+		t.Errorf("unexpected databases: %v", parsed.Backup.Databases)
+	}
+}
+
+func TestInterpolatedExplicitString(t *testing.T) {
+	inTempDir(t, map[string]string{"pliz.yml": "version: 3\nenvironments:\n  prod:\n    protected: !!str ${PROTECTED:-true}\n"})
+
+	if _, _, err := readConfigFile(""); err == nil {
+		t.Error("expected an error: the value is a string")
+	}
+}
@@ -0,0 +1,255 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the version of the config schema handled by this version of pliz
+//
+// History:
+//   - 1: rev 1 layout, the tasks are defined in 'enabled_tasks' (the default tasks use 'override')
+//   - 2: rev 2 layout, 'enabled_tasks' is renamed 'install_tasks' and the tasks are defined in 'tasks'
+//   - 3: the values can use variables ('${VAR}'), a literal '$' is written '$$'
+//
+// The files of the version 1 already using the rev 2 layout are still supported.
+const CurrentVersion = 3
+
+// interpolationVersion is the first version whose values are interpolated
+const interpolationVersion = 3
+
+// checkVersion refuses the config files written for a newer pliz or still using the rev 1 layout
+func (parsed parserConfig) checkVersion() error {
+	if parsed.Version > CurrentVersion {
+		return fmt.Errorf("The config file uses the version %d, but this version of pliz only supports the version %d. Please update pliz.", parsed.Version, CurrentVersion)
+	}
+
+	if parsed.EnabledTasks != nil {
+		return fmt.Errorf("The config file uses the old 'enabled_tasks' section. Run 'pliz config migrate' to update it.")
+	}
+
+	return nil
+}
+
+// MigratedFile is a config file rewritten by Migrate
+type MigratedFile struct {
+	Filename string
+	Content  []byte // migrated content
+	Changes  []string
+}
+
+// Migrate rewrites the config file and its overlays (pliz.<env>.yml, pliz.local.yml) to the current version
+// (comments are preserved). The migrated files are returned, they are written only if write is true.
+func Migrate(write bool) ([]MigratedFile, error) {
+	if _, err := os.Stat(defaultFilename); err != nil {
+		return nil, fmt.Errorf("Unable to find a config file 'pliz.yml' in the current directory")
+	}
+
+	overlays, err := filepath.Glob("pliz.*.yml")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(overlays)
+
+	migratedFiles := []MigratedFile{}
+	baseVersion := -1
+	for _, filename := range append([]string{defaultFilename}, overlays...) {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		migrated, changes, version, err := migrateContent(content, filename, baseVersion)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		if filename == defaultFilename {
+			baseVersion = version
+		}
+		if len(changes) > 0 {
+			migratedFiles = append(migratedFiles, MigratedFile{Filename: filename, Content: migrated, Changes: changes})
+		}
+	}
+
+	if write {
+		for _, file := range migratedFiles {
+			content, err := ioutil.ReadFile(file.Filename)
+			if err != nil {
+				return nil, err
+			}
+			if err := ioutil.WriteFile(file.Filename+".bak", content, 0644); err != nil {
+				return nil, err
+			}
+			if err := ioutil.WriteFile(file.Filename, file.Content, 0644); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return migratedFiles, nil
+}
+
+// migrateContent migrates the content of a config file, returning the version it was written for.
+// The baseVersion is the version of pliz.yml for an overlay (-1 for pliz.yml): the version is only set
+// in the overlays declaring it (the one of pliz.yml is used otherwise).
+func migrateContent(content []byte, filename string, baseVersion int) ([]byte, []string, int, error) {
+	overlay := baseVersion >= 0
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, nil, 0, fmt.Errorf("Unable to parse the config file. Check '%s' syntax.\n%v", filename, err)
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, 0, fmt.Errorf("The config file must be a YAML mapping")
+	}
+	root := document.Content[0]
+
+	changes := []string{}
+
+	version := 0
+	if overlay {
+		version = baseVersion
+	}
+	versionNode := mappingValue(root, "version")
+	if versionNode != nil {
+		v, err := strconv.Atoi(versionNode.Value)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("Invalid version '%s'", versionNode.Value)
+		}
+		version = v
+	}
+	if version > CurrentVersion {
+		return nil, nil, 0, fmt.Errorf("The config file uses the version %d, but this version of pliz only supports the version %d", version, CurrentVersion)
+	}
+
+	// rev 1 -> rev 2
+	if enabledTasks := mappingKey(root, "enabled_tasks"); enabledTasks != nil {
+		if mappingKey(root, "install_tasks") != nil {
+			return nil, nil, 0, fmt.Errorf("The config file contains both 'enabled_tasks' and 'install_tasks'")
+		}
+
+		installTasks := mappingValue(root, "enabled_tasks")
+		enabledTasks.Value = "install_tasks"
+		changes = append(changes, "'enabled_tasks' renamed 'install_tasks'")
+
+		// the task definitions are moved into 'tasks'
+		tasks := mappingValue(root, "tasks")
+		if tasks == nil {
+			tasks = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "tasks"}, tasks)
+		}
+
+		if installTasks != nil && installTasks.Kind == yaml.SequenceNode {
+			for i, item := range installTasks.Content {
+				if item.Kind != yaml.MappingNode {
+					continue
+				}
+
+				name := mappingValue(item, "name")
+				if name == nil {
+					return nil, nil, 0, fmt.Errorf("enabled_tasks[%d]: 'name' is required", i)
+				}
+
+				// the 'override' keyword is not needed anymore
+				if removeMappingKey(item, "override") {
+					changes = append(changes, fmt.Sprintf("'override' removed from the task '%s'", name.Value))
+				}
+
+				tasks.Content = append(tasks.Content, item)
+				installTasks.Content[i] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name.Value, LineComment: item.LineComment}
+				changes = append(changes, fmt.Sprintf("task '%s' moved into 'tasks'", name.Value))
+			}
+		}
+	}
+
+	// version 3: the '$' were not interpolated
+	if version < interpolationVersion {
+		if count := escapeDollars(root); count > 0 {
+			changes = append(changes, fmt.Sprintf("'$' escaped as '$$' in %d value(s)", count))
+		}
+	}
+
+	if version != CurrentVersion && (!overlay || versionNode != nil) {
+		if versionNode != nil {
+			versionNode.Value = strconv.Itoa(CurrentVersion)
+		} else {
+			versionKey := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+			// keep the comment at the top of the file
+			if len(root.Content) > 0 {
+				versionKey.HeadComment = root.Content[0].HeadComment
+				root.Content[0].HeadComment = ""
+			}
+			root.Content = append([]*yaml.Node{
+				versionKey,
+				{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(CurrentVersion)},
+			}, root.Content...)
+		}
+		changes = append(changes, fmt.Sprintf("version set to %d", CurrentVersion))
+	}
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, nil, 0, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, nil, 0, err
+	}
+
+	return buffer.Bytes(), changes, version, nil
+}
+
+// escapeDollars replaces '$' by '$$' in the values of the node (as interpolateNode, the keys are kept),
+// returning the number of values changed
+func escapeDollars(node *yaml.Node) int {
+	count := 0
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			count += escapeDollars(child)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			count += escapeDollars(node.Content[i+1])
+		}
+	case yaml.ScalarNode:
+		if strings.Contains(node.Value, "$") {
+			node.Value = strings.ReplaceAll(node.Value, "$", "$$")
+			count++
+		}
+	}
+	return count
+}
+
+// mappingKey returns the key node of a mapping node (nil if not found)
+func mappingKey(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i]
+		}
+	}
+	return nil
+}
+
+// removeMappingKey removes the key (and its value) from a mapping node
+func removeMappingKey(node *yaml.Node, key string) bool {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return true
+		}
+	}
+	return false
+}
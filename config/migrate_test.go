@@ -0,0 +1,179 @@
+package config
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrateContent(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		baseVersion int // -1 for pliz.yml
+		expected    string
+		changes     []string
+		err         string
+	}{
+		{
+			name:        "up to date",
+			content:     "version: 3\ninstall_tasks: [npm]\n",
+			baseVersion: -1,
+			expected:    "version: 3\ninstall_tasks: [npm]\n",
+			changes:     []string{},
+		},
+		{
+			name:        "version added",
+			content:     "# my project\ninstall_tasks: [npm]\n",
+			baseVersion: -1,
+			expected:    "# my project\nversion: 3\ninstall_tasks: [npm]\n",
+			changes:     []string{"version set to 3"},
+		},
+		{
+			name:        "rev 1 layout",
+			content:     "version: 1\nenabled_tasks:\n  - npm\n  - name: npm\n    override: true\n    command: [npm, ci]\n  - name: cache # clear the cache\n    container: app\n    command: [php, artisan, cache:clear]\n",
+			baseVersion: -1,
+			expected:    "version: 3\ninstall_tasks:\n- npm\n- npm\n- cache\ntasks:\n- name: npm\n  command: [npm, ci]\n- name: cache # clear the cache\n  container: app\n  command: [php, artisan, 'cache:clear']\n",
+			changes: []string{
+				"'enabled_tasks' renamed 'install_tasks'",
+				"'override' removed from the task 'npm'",
+				"task 'npm' moved into 'tasks'",
+				"task 'cache' moved into 'tasks'",
+				"version set to 3",
+			},
+		},
+		{
+			name:        "dollars escaped",
+			content:     "version: 2\ntasks:\n  - name: hello\n    command: [sh, -c, 'echo $HOME ${USER}']\n    env: {$KEY: $$}\n",
+			baseVersion: -1,
+			expected:    "version: 3\ntasks:\n- name: hello\n  command: [sh, -c, 'echo $$HOME $${USER}']\n  env: {$KEY: $$$$}\n",
+			changes:     []string{"'$' escaped as '$$' in 2 value(s)", "version set to 3"},
+		},
+		{
+			name:        "overlay of a version 2 config",
+			content:     "startup_container: $web\n",
+			baseVersion: 2,
+			expected:    "startup_container: $$web\n",
+			changes:     []string{"'$' escaped as '$$' in 1 value(s)"},
+		},
+		{
+			name:        "overlay of a version 3 config",
+			content:     "startup_container: ${WEB}\n",
+			baseVersion: 3,
+			expected:    "startup_container: ${WEB}\n",
+			changes:     []string{},
+		},
+		{
+			name:        "overlay declaring its version",
+			content:     "version: 2\nstartup_container: $web\n",
+			baseVersion: 3,
+			expected:    "version: 3\nstartup_container: $$web\n",
+			changes:     []string{"'$' escaped as '$$' in 1 value(s)", "version set to 3"},
+		},
+		{
+			name:        "overlay using the rev 1 layout",
+			content:     "enabled_tasks: [{name: npm, command: [npm, ci]}]\n",
+			baseVersion: 3,
+			expected:    "install_tasks: [npm]\ntasks:\n- {name: npm, command: [npm, ci]}\n",
+			changes:     []string{"'enabled_tasks' renamed 'install_tasks'", "task 'npm' moved into 'tasks'"},
+		},
+		{
+			name:        "newer version",
+			content:     "version: 4\n",
+			baseVersion: -1,
+			err:         "only supports the version 3",
+		},
+		{
+			name:        "both layouts",
+			content:     "enabled_tasks: [npm]\ninstall_tasks: [npm]\n",
+			baseVersion: -1,
+			err:         "both 'enabled_tasks' and 'install_tasks'",
+		},
+		{
+			name:        "task without name",
+			content:     "enabled_tasks: [{command: [ls]}]\n",
+			baseVersion: -1,
+			err:         "enabled_tasks[0]: 'name' is required",
+		},
+		{
+			name:        "not a mapping",
+			content:     "- npm\n",
+			baseVersion: -1,
+			err:         "must be a YAML mapping",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			migrated, changes, _, err := migrateContent([]byte(test.content), "pliz.yml", test.baseVersion)
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected the error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(migrated) != test.expected {
+				t.Errorf("unexpected content:\n%s\nexpected:\n%s", migrated, test.expected)
+			}
+			if !reflect.DeepEqual(changes, test.changes) {
+				t.Errorf("expected the changes %q, got %q", test.changes, changes)
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	inTempDir(t, map[string]string{
+		"pliz.yml":       "version: 2\nenabled_tasks: [{name: hello, container: none, command: [sh, -c, echo $HOME]}]\n",
+		"pliz.prod.yml":  "enabled_tasks: [{name: hello, command: [echo, $PWD]}]\n",
+		"pliz.local.yml": "startup_container: web\n",
+	})
+
+	// the files are not written in dry-run mode
+	migratedFiles, err := Migrate(false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	filenames := []string{}
+	for _, file := range migratedFiles {
+		filenames = append(filenames, file.Filename)
+	}
+	if !reflect.DeepEqual(filenames, []string{"pliz.yml", "pliz.prod.yml"}) {
+		t.Fatalf("unexpected migrated files: %v", filenames)
+	}
+	if _, _, err := readConfigFile("prod"); err == nil {
+		t.Fatal("the files must not be written")
+	}
+
+	if _, err := Migrate(true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, file := range migratedFiles {
+		content, err := ioutil.ReadFile(file.Filename)
+		if err != nil || string(content) != string(file.Content) {
+			t.Errorf("%s is not migrated: %s", file.Filename, content)
+		}
+		if _, err := ioutil.ReadFile(file.Filename + ".bak"); err != nil {
+			t.Errorf("the previous content of %s is not saved: %v", file.Filename, err)
+		}
+	}
+
+	parsed, _, err := readConfigFile("prod")
+	if err != nil {
+		t.Fatalf("the migrated config can't be read: %v", err)
+	}
+	if len(parsed.Tasks) != 1 || !reflect.DeepEqual(parsed.Tasks[0].CommandArgs, []string{"echo", "$PWD"}) {
+		t.Errorf("unexpected tasks: %+v", parsed.Tasks)
+	}
+
+	// already up to date
+	migratedFiles, err = Migrate(true)
+	if err != nil || len(migratedFiles) != 0 {
+		t.Errorf("expected no migration, got %v (%v)", migratedFiles, err)
+	}
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+const localFilename = "pliz.local.yml"
+
+// configSource is a parsed config file: pliz.yml or one of its overlays
+type configSource struct {
+	Filename string
+	Content  []byte
+	Document yaml.Node // parsed content, with the variables expanded
+}
+
+// overlayFilenames returns the files merged on top of pliz.yml, in order: pliz.<env>.yml then pliz.local.yml
+func overlayFilenames(env string) []string {
+	filenames := []string{}
+	if env != "" && fmt.Sprintf("pliz.%s.yml", env) != localFilename {
+		filenames = append(filenames, fmt.Sprintf("pliz.%s.yml", env))
+	}
+	return append(filenames, localFilename)
+}
+
+// merge returns the config with the overlay applied on top of it:
+//   - the values (startup_container, default_tasks_check...) are replaced
+//   - 'containers' and 'config_files' are merged by key
+//   - 'install_tasks' is replaced (the order of the tasks matters)
+//   - 'additional_startup_containers', 'checklist' and 'backup.files' are appended
+//   - 'tasks' are merged by name: the fields set in the overlay replace the ones of the task ('env' is merged by key),
+//     a default task not declared in pliz.yml is merged onto its default definition
+//   - 'backup.databases' are merged by container: the overlay entry replaces the previous one
+//   - 'environments' are merged by name: the overlay entry replaces the previous one
+//   - 'backup.recipients' are replaced
+func (parsed parserConfig) merge(overlay parserConfig) parserConfig {
+	merged := parsed
+
+	if overlay.Version != 0 {
+		merged.Version = overlay.Version
+	}
+	if overlay.StartupContainer != "" {
+		merged.StartupContainer = overlay.StartupContainer
+	}
+	if overlay.DefaultTasksCheck != "" {
+		merged.DefaultTasksCheck = overlay.DefaultTasksCheck
+	}
+
+	merged.Containers = mergeMaps(parsed.Containers, overlay.Containers)
+	merged.ConfigFiles = mergeMaps(parsed.ConfigFiles, overlay.ConfigFiles)
+
+	if overlay.InstallTasks != nil {
+		merged.InstallTasks = overlay.InstallTasks
+	}
+
+	merged.AdditionalStartupContainers = appendMissing(parsed.AdditionalStartupContainers, overlay.AdditionalStartupContainers)
+	merged.Checklist = append(append([]string{}, parsed.Checklist...), overlay.Checklist...)
+	merged.Backup.Files = appendMissing(parsed.Backup.Files, overlay.Backup.Files)
+	if overlay.Backup.Recipients != nil {
+		merged.Backup.Recipients = overlay.Backup.Recipients
+	}
+
+	// tasks
+	merged.Tasks = append([]TaskSpec{}, parsed.Tasks...)
+	for _, overlayTask := range overlay.Tasks {
+		found := false
+		for i := range merged.Tasks {
+			if merged.Tasks[i].Name == overlayTask.Name {
+				merged.Tasks[i] = merged.Tasks[i].merge(overlayTask)
+				found = true
+				break
+			}
+		}
+		if !found {
+			overlayTask.overlayOnly = true
+			merged.Tasks = append(merged.Tasks, overlayTask)
+		}
+	}
+
+	// databases
+	if parsed.Backup.Databases != nil || overlay.Backup.Databases != nil {
+		// nil if the section is omitted everywhere (the db service is used by default)
+		merged.Backup.Databases = append([]DatabaseBackupSpec{}, parsed.Backup.Databases...)
+	}
+	for _, overlayDatabase := range overlay.Backup.Databases {
+		found := false
+		for i := range merged.Backup.Databases {
+			if merged.Backup.Databases[i].Container == overlayDatabase.Container {
+				merged.Backup.Databases[i] = overlayDatabase
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged.Backup.Databases = append(merged.Backup.Databases, overlayDatabase)
+		}
+	}
+
+	// environments
+	if parsed.Environments != nil || overlay.Environments != nil {
+		merged.Environments = map[string]EnvironmentSpec{}
+		for name, environment := range parsed.Environments {
+			merged.Environments[name] = environment
+		}
+		for name, environment := range overlay.Environments {
+			merged.Environments[name] = environment
+		}
+	}
+
+	if overlay.EnabledTasks != nil {
+		merged.EnabledTasks = overlay.EnabledTasks
+	}
+
+	return merged
+}
+
+// merge returns the task with the fields set in the overlay
+func (task TaskSpec) merge(overlay TaskSpec) TaskSpec {
+	merged := task
+
+	if overlay.Description != "" {
+		merged.Description = overlay.Description
+	}
+	if overlay.Container != "" {
+		merged.Container = overlay.Container
+	}
+	if overlay.Mode != "" {
+		merged.Mode = overlay.Mode
+	}
+	if len(overlay.CommandArgs) > 0 {
+		merged.CommandArgs = overlay.CommandArgs
+	}
+	if overlay.DependsOn != nil {
+		merged.DependsOn = overlay.DependsOn
+	}
+	if overlay.Check != nil {
+		merged.Check = overlay.Check
+	}
+	if overlay.WorkingDir != "" {
+		merged.WorkingDir = overlay.WorkingDir
+	}
+	if overlay.User != "" {
+		merged.User = overlay.User
+	}
+	merged.Env = mergeMaps(task.Env, overlay.Env)
+
+	return merged
+}
+
+func mergeMaps(base map[string]string, overlay map[string]string) map[string]string {
+	if base == nil && overlay == nil {
+		return nil
+	}
+
+	merged := map[string]string{}
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range overlay {
+		merged[key] = value
+	}
+	return merged
+}
+
+func appendMissing(base []string, items []string) []string {
+	merged := append([]string{}, base...)
+	for _, item := range items {
+		found := false
+		for _, existing := range merged {
+			if existing == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
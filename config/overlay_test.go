@@ -0,0 +1,178 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"webup/pliz/domain"
+
+	"gopkg.in/yaml.v3"
+)
+
+func parseConfig(t *testing.T, content string) parserConfig {
+	t.Helper()
+
+	var parsed parserConfig
+	if err := yaml.Unmarshal([]byte(content), &parsed); err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+	return parsed
+}
+
+// the configs are compared once marshalled: the nil and the empty lists are the same
+func marshalConfig(t *testing.T, parsed parserConfig) string {
+	t.Helper()
+
+	content, err := yaml.Marshal(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func domainConfig(t *testing.T, parsed parserConfig) domain.Config {
+	t.Helper()
+
+	config := domain.Config{}
+	if err := parsed.convertToConfig(&config); err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+	return config
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		overlay  string
+		expected string
+	}{
+		{
+			name:     "values replaced",
+			base:     "version: 3\nstartup_container: proxy\ndefault_tasks_check: modification_date",
+			overlay:  "startup_container: web",
+			expected: "version: 3\nstartup_container: web\ndefault_tasks_check: modification_date",
+		},
+		{
+			name:     "maps merged by key",
+			base:     "containers: {app: php, builder: node}\nconfig_files: {.env.dist: .env}",
+			overlay:  "containers: {app: php-fpm, db: mysql}",
+			expected: "containers: {app: php-fpm, builder: node, db: mysql}\nconfig_files: {.env.dist: .env}",
+		},
+		{
+			name:     "install tasks replaced",
+			base:     "install_tasks: [npm, composer]",
+			overlay:  "install_tasks: [composer]",
+			expected: "install_tasks: [composer]",
+		},
+		{
+			name:     "install tasks kept",
+			base:     "install_tasks: [npm, composer]",
+			overlay:  "startup_container: web",
+			expected: "install_tasks: [npm, composer]\nstartup_container: web",
+		},
+		{
+			name:     "lists appended",
+			base:     "additional_startup_containers: [worker]\nchecklist: [Check the logs]\nbackup: {files: [storage]}",
+			overlay:  "additional_startup_containers: [worker, cron]\nchecklist: [Check the logs]\nbackup: {files: [storage, uploads]}",
+			expected: "additional_startup_containers: [worker, cron]\nchecklist: [Check the logs, Check the logs]\nbackup: {files: [storage, uploads]}",
+		},
+		{
+			name: "tasks merged by name",
+			base: `
+tasks:
+  - {name: assets, description: Build the assets, container: node, command: [npm, run, dev], env: {A: "1", B: "1"}}
+  - {name: migrate, container: app, command: [php, artisan, migrate]}`,
+			overlay: `
+tasks:
+  - {name: assets, command: [npm, run, prod], env: {B: "2"}, user: node}`,
+			expected: `
+tasks:
+  - {name: assets, description: Build the assets, container: node, command: [npm, run, prod], env: {A: "1", B: "2"}, user: node}
+  - {name: migrate, container: app, command: [php, artisan, migrate]}`,
+		},
+		{
+			name:     "task check replaced",
+			base:     "tasks: [{name: npm, command: [npm, ci], check: {type: content_hash, files: [package.json]}}]",
+			overlay:  "tasks: [{name: npm, check: {type: none}}]",
+			expected: "tasks: [{name: npm, command: [npm, ci], check: {type: none}}]",
+		},
+		{
+			name:     "databases omitted",
+			base:     "backup: {files: [storage]}",
+			overlay:  "backup: {files: [uploads]}",
+			expected: "backup: {files: [storage, uploads]}",
+		},
+		{
+			name:     "databases merged by container",
+			base:     "backup: {databases: [{container: db, databases: [app]}, {container: mongo}]}",
+			overlay:  "backup: {databases: [{container: db, all_databases: true}, {container: cache}]}",
+			expected: "backup: {databases: [{container: db, all_databases: true}, {container: mongo}, {container: cache}]}",
+		},
+		{
+			name:     "recipients replaced",
+			base:     "backup: {recipients: [pliz-pub-a, pliz-pub-b]}",
+			overlay:  "backup: {recipients: [pliz-pub-c]}",
+			expected: "backup: {recipients: [pliz-pub-c]}",
+		},
+		{
+			name:     "environments merged by name",
+			base:     "environments: {staging: {protected: false}, preprod: {protected: true}}",
+			overlay:  "environments: {staging: {protected: true}, demo: {protected: false}}",
+			expected: "environments: {staging: {protected: true}, preprod: {protected: true}, demo: {protected: false}}",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged := parseConfig(t, test.base).merge(parseConfig(t, test.overlay))
+
+			if actual, expected := marshalConfig(t, merged), marshalConfig(t, parseConfig(t, test.expected)); actual != expected {
+				t.Errorf("unexpected merged config:\n%s\nexpected:\n%s", actual, expected)
+			}
+		})
+	}
+}
+
+func TestMergeDatabasesOmitted(t *testing.T) {
+	merged := parseConfig(t, "startup_container: proxy").merge(parseConfig(t, "startup_container: web"))
+
+	// the db service is backed up by default
+	if merged.Backup.Databases != nil {
+		t.Errorf("expected no databases section, got %v", merged.Backup.Databases)
+	}
+}
+
+func TestMergeOverlayOnlyDefaultTask(t *testing.T) {
+	merged := parseConfig(t, "version: 3").merge(parseConfig(t, "tasks: [{name: npm, description: Install the packages, container: node}]"))
+
+	if len(merged.Tasks) != 1 || !merged.Tasks[0].overlayOnly {
+		t.Fatalf("expected a task declared in the overlay only, got %+v", merged.Tasks)
+	}
+
+	config := domainConfig(t, merged)
+	npm := config.Tasks["npm"]
+	if npm.Description != "Install the packages" || npm.Container == nil || *npm.Container != "node" {
+		t.Errorf("the overlay is not applied: %+v", npm)
+	}
+	// the command of the default task is kept
+	if !reflect.DeepEqual([]string(npm.CommandArgs), []string{"npm", "install"}) {
+		t.Errorf("expected the command of the default task, got %v", npm.CommandArgs)
+	}
+}
+
+func TestOverlayFilenames(t *testing.T) {
+	tests := []struct {
+		env      string
+		expected []string
+	}{
+		{"", []string{"pliz.local.yml"}},
+		{"prod", []string{"pliz.prod.yml", "pliz.local.yml"}},
+		{"local", []string{"pliz.local.yml"}},
+	}
+
+	for _, test := range tests {
+		if filenames := overlayFilenames(test.env); !reflect.DeepEqual(filenames, test.expected) {
+			t.Errorf("env '%s': expected %v, got %v", test.env, test.expected, filenames)
+		}
+	}
+}
@@ -1,12 +1,28 @@
 package config
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"webup/pliz/domain"
+
+	"gopkg.in/yaml.v3"
+)
 
 type TaskSpec struct {
 	Name        string   `yaml:"name"`
 	Description string   `yaml:"description"`
 	Container   string   `yaml:"container"`
+	Mode        string   `yaml:"mode"`
 	CommandArgs []string `yaml:"command"`
+	DependsOn   []string `yaml:"depends_on"`
+
+	Env        map[string]string `yaml:"env"`
+	WorkingDir string            `yaml:"workdir"`
+	User       string            `yaml:"user"`
+
+	Check *ExecutionCheckSpec `yaml:"check"`
+
+	overlayOnly bool // declared in an overlay only: a default task is merged onto its default definition
 }
 
 func (task TaskSpec) IsValidForCustomTask() error {
@@ -23,9 +39,90 @@ func (task TaskSpec) IsValidForCustomTask() error {
 	return nil
 }
 
+func (task TaskSpec) taskDependencies() []domain.TaskID {
+	dependencies := []domain.TaskID{}
+	for _, name := range task.DependsOn {
+		dependencies = append(dependencies, domain.TaskID(name))
+	}
+	return dependencies
+}
+
+// ExecutionCheckSpec describes the check performed to know if a task must be executed
+type ExecutionCheckSpec struct {
+	Type string `yaml:"type"` // modification_date|content_hash|missing_path|command|none
+
+	UpdatedFile string       `yaml:"updated_file"` // modification_date
+	CompareTo   string       `yaml:"compare_to"`   // modification_date
+	Files       []string     `yaml:"files"`        // content_hash
+	Target      string       `yaml:"target"`       // content_hash, optional
+	Path        string       `yaml:"path"`         // missing_path
+	Command     CheckCommand `yaml:"command"`      // command
+}
+
+// CheckCommand is the command of a 'command' check: a shell command (run with 'sh -c') or the list of its args
+type CheckCommand []string
+
+func (c *CheckCommand) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*c = CheckCommand{"sh", "-c", value.Value}
+		return nil
+	}
+
+	var args []string
+	if err := value.Decode(&args); err != nil {
+		return err
+	}
+	*c = args
+	return nil
+}
+
+// toExecutionCheck creates the check of the task. A nil check is returned for the type 'none'.
+func (check ExecutionCheckSpec) toExecutionCheck(taskName string) (domain.TaskExecutionCheck, error) {
+	switch check.Type {
+	case "modification_date":
+		if check.UpdatedFile == "" || check.CompareTo == "" {
+			return nil, errors.New("'updated_file' and 'compare_to' are required for a 'modification_date' check")
+		}
+		return &domain.ModificationDateTaskExecutionCheck{UpdatedFile: check.UpdatedFile, CompareTo: check.CompareTo}, nil
+	case "content_hash":
+		if len(check.Files) == 0 {
+			return nil, errors.New("'files' is required for a 'content_hash' check")
+		}
+		return &domain.ContentHashTaskExecutionCheck{Key: taskName, Files: check.Files, Target: check.Target}, nil
+	case "missing_path":
+		if check.Path == "" {
+			return nil, errors.New("'path' is required for a 'missing_path' check")
+		}
+		return &domain.MissingPathTaskExecutionCheck{Path: check.Path}, nil
+	case "command":
+		if len(check.Command) == 0 {
+			return nil, errors.New("'command' is required for a 'command' check (a shell command, or the list of its args)")
+		}
+		return &domain.CommandTaskExecutionCheck{CommandArgs: domain.CommandArgs(check.Command)}, nil
+	case "none":
+		return nil, nil
+	case "":
+		return nil, errors.New("'type' is required")
+	}
+
+	return nil, fmt.Errorf("unsupported type '%s' (modification_date, content_hash, missing_path, command or none)", check.Type)
+}
+
+func (task TaskSpec) containerMode() (domain.ContainerMode, error) {
+	switch domain.ContainerMode(task.Mode) {
+	case "", domain.RunMode:
+		return domain.RunMode, nil
+	case domain.ExecMode:
+		return domain.ExecMode, nil
+	}
+
+	return "", fmt.Errorf("unsupported mode '%s' (run or exec)", task.Mode)
+}
+
 type BackupSpec struct {
-	Files     []string             `yaml:"files"`     // list of the files/directories to backup
-	Databases []DatabaseBackupSpec `yaml:"databases"` // list of the db to backup
+	Files      []string             `yaml:"files"`      // list of the files/directories to backup
+	Databases  []DatabaseBackupSpec `yaml:"databases"`  // list of the db to backup
+	Recipients []string             `yaml:"recipients"` // public keys of the people who can decrypt the backups
 }
 
 type DatabaseBackupSpec struct {
@@ -35,3 +132,7 @@ type DatabaseBackupSpec struct {
 	AllDatabases bool     `yaml:"all_databases"`
 	Databases    []string `yaml:"databases"`
 }
+
+type EnvironmentSpec struct {
+	Protected bool `yaml:"protected"` // ask for a confirmation before the dangerous operations
+}
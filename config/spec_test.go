@@ -0,0 +1,103 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"webup/pliz/domain"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestExecutionCheckSpec(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		expected domain.TaskExecutionCheck
+		err      string
+	}{
+		{
+			name:     "modification date",
+			spec:     "type: modification_date\nupdated_file: package.json\ncompare_to: node_modules",
+			expected: &domain.ModificationDateTaskExecutionCheck{UpdatedFile: "package.json", CompareTo: "node_modules"},
+		},
+		{
+			name: "modification date without compare_to",
+			spec: "type: modification_date\nupdated_file: package.json",
+			err:  "'updated_file' and 'compare_to' are required",
+		},
+		{
+			name:     "content hash",
+			spec:     "type: content_hash\nfiles: [package.json, 'src/*']\ntarget: node_modules",
+			expected: &domain.ContentHashTaskExecutionCheck{Key: "npm", Files: []string{"package.json", "src/*"}, Target: "node_modules"},
+		},
+		{
+			name: "content hash without files",
+			spec: "type: content_hash",
+			err:  "'files' is required",
+		},
+		{
+			name:     "missing path",
+			spec:     "type: missing_path\npath: vendor",
+			expected: &domain.MissingPathTaskExecutionCheck{Path: "vendor"},
+		},
+		{
+			name: "missing path without path",
+			spec: "type: missing_path",
+			err:  "'path' is required",
+		},
+		{
+			name:     "shell command",
+			spec:     "type: command\ncommand: \"! grep -q '^APP_KEY=.' .env | cat && true\"",
+			expected: &domain.CommandTaskExecutionCheck{CommandArgs: domain.CommandArgs{"sh", "-c", "! grep -q '^APP_KEY=.' .env | cat && true"}},
+		},
+		{
+			name:     "command args",
+			spec:     "type: command\ncommand: [test, -f, .env]",
+			expected: &domain.CommandTaskExecutionCheck{CommandArgs: domain.CommandArgs{"test", "-f", ".env"}},
+		},
+		{
+			name: "command without command",
+			spec: "type: command",
+			err:  "'command' is required for a 'command' check (a shell command, or the list of its args)",
+		},
+		{
+			name:     "none",
+			spec:     "type: none",
+			expected: nil,
+		},
+		{
+			name: "without type",
+			spec: "path: vendor",
+			err:  "'type' is required",
+		},
+		{
+			name: "unknown type",
+			spec: "type: always",
+			err:  "unsupported type 'always'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var spec ExecutionCheckSpec
+			if err := yaml.Unmarshal([]byte(test.spec), &spec); err != nil {
+				t.Fatal(err)
+			}
+
+			check, err := spec.toExecutionCheck("npm")
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected an error containing '%s', got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(check, test.expected) {
+				t.Errorf("expected %#v, got %#v", test.expected, check)
+			}
+		})
+	}
+}
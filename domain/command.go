@@ -1,10 +1,12 @@
 package domain
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
+	"regexp"
 	"strings"
 
 	"github.com/fatih/color"
@@ -12,67 +14,138 @@ import (
 
 type CommandArgs []string
 
+// CommandError is returned when a command has been run but exited with a non-zero status
+type CommandError struct {
+	Command  Command
+	ExitCode int
+	Err      error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("'%s' failed: %v", e.Command.Name, e.Err)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the exit code to use for the given error (1 if the error doesn't carry one)
+func ExitCode(err error) int {
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) && cmdErr.ExitCode > 0 {
+		return cmdErr.ExitCode
+	}
+	return 1
+}
+
 type Command struct {
 	Name    string
 	Args    []string
 	Verbose bool
+
+	Env []string // additional environment variables (KEY=VALUE)
+	Dir string   // working directory, the current one if empty
+}
+
+// secretPattern matches the 'NAME=VALUE' (or '--option=VALUE') holding a secret, i.e. '--password=...' or 'DB_PASSWORD=...'
+var secretPattern = regexp.MustCompile(`(?i)([\w.-]*(?:pass|pwd|secret|token|key)[\w.-]*=)("[^"]*"|'[^']*'|[^\s"']+)`)
+
+// mysqlClients accept the password as '-pVALUE'
+var mysqlClients = map[string]bool{"mysql": true, "mysqldump": true, "mysqladmin": true, "mariadb": true, "mariadb-dump": true, "mariadb-admin": true}
+
+// RedactSecrets replaces the values of the secrets of the text by '****' (see secretPattern)
+func RedactSecrets(text string) string {
+	return secretPattern.ReplaceAllString(text, "${1}****")
 }
 
+// String returns the command line with its env and its working directory ('K=V cmd args (in dir)'),
+// with the secrets redacted (it's used everywhere a command is printed)
 func (c Command) String() string {
-	return fmt.Sprintf("%s %s", c.Name, strings.Join(c.Args, " "))
+	parts := []string{}
+	for _, variable := range c.Env {
+		parts = append(parts, RedactSecrets(variable))
+	}
+	parts = append(parts, c.Name)
+
+	mysqlClient := mysqlClients[c.Name]
+	for _, arg := range c.Args {
+		if mysqlClient && strings.HasPrefix(arg, "-p") && len(arg) > 2 {
+			arg = "-p****"
+		}
+		mysqlClient = mysqlClient || mysqlClients[arg]
+		parts = append(parts, RedactSecrets(arg))
+	}
+
+	line := strings.Join(parts, " ")
+	if c.Dir != "" {
+		line += fmt.Sprintf(" (in %s)", c.Dir)
+	}
+	return line
 }
 
-func (c Command) Execute() {
-	cmd := exec.Command(c.Name, c.Args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
+func (c Command) Execute() error {
+	if dryRun {
+		PrintDryRun("%s", c)
+		return nil
+	}
 
 	if c.Verbose {
 		fmt.Printf("%s %s\n", color.MagentaString("Executing:"), c)
 	}
 
-	cmd.Run()
+	return c.wrapError(currentRunner.Run(c, os.Stdin, os.Stdout, os.Stderr))
 }
 
-func (c Command) GetRawExecCommand() *exec.Cmd {
-	return exec.Command(c.Name, c.Args...)
+// ExecuteWithOutput runs the command without stdin, writing its output to stdout and stderr
+func (c Command) ExecuteWithOutput(stdout io.Writer, stderr io.Writer) error {
+	if dryRun {
+		FprintDryRun(stdout, "%s", c)
+		return nil
+	}
+
+	if c.Verbose {
+		fmt.Fprintf(stdout, "%s %s\n", color.MagentaString("Executing:"), c)
+	}
+
+	return c.wrapError(currentRunner.Run(c, nil, stdout, stderr))
 }
 
-func (c Command) ExecuteWithStdin(reader io.Reader) {
-	cmd := exec.Command(c.Name, c.Args...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	cmd.Stdin = reader
+func (c Command) ExecuteWithStdin(reader io.Reader) error {
+	if dryRun {
+		PrintDryRun("%s < (stdin)", c)
+		return nil
+	}
 
 	if c.Verbose {
 		fmt.Printf("%s %s\n", color.MagentaString("Executing:"), c)
 	}
 
-	cmd.Run()
+	return c.wrapError(currentRunner.Run(c, reader, os.Stdout, os.Stderr))
 }
 
+// GetResult runs the command and returns its output.
+// It must only be used for queries: the command is run even in dry-run mode.
 func (c Command) GetResult() (string, error) {
-	cmd := exec.Command(c.Name, c.Args...)
+	var out bytes.Buffer
 
-	out, err := cmd.Output()
+	err := currentRunner.Run(c, nil, &out, nil)
 	if err != nil {
-		return "", err
+		return "", c.wrapError(err)
 	}
 
-	output := strings.TrimSpace(string(out))
+	output := strings.TrimSpace(out.String())
 
 	return output, nil
 }
 
 func (c Command) WriteResultToFile(file *os.File) error {
-	cmd := exec.Command(c.Name, c.Args...)
-
-	cmd.Stdout = file
-	cmd.Stderr = os.Stderr
+	if dryRun {
+		PrintDryRun("%s > %s", c, file.Name())
+		return nil
+	}
 
-	if err := cmd.Run(); err != nil {
-		return err
+	if err := currentRunner.Run(c, nil, file, os.Stderr); err != nil {
+		return c.wrapError(err)
 	}
 	if c.Verbose {
 		fmt.Printf("Executing: %s\n", c)
@@ -82,6 +155,21 @@ func (c Command) WriteResultToFile(file *os.File) error {
 	return nil
 }
 
+// wrapError converts the error of exec.Cmd.Run into a CommandError holding the exit code
+func (c Command) wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// *exec.ExitError (or any error of a Runner) exposing the exit code of the process
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return &CommandError{Command: c, ExitCode: exitErr.ExitCode(), Err: err}
+	}
+
+	return &CommandError{Command: c, ExitCode: 1, Err: err}
+}
+
 func NewCommand(list []string, verbose bool) Command {
 	var name string
 	var args []string
@@ -97,21 +185,22 @@ func NewCommand(list []string, verbose bool) Command {
 	return Command{Name: name, Args: args, Verbose: verbose}
 }
 
-func NewComposeCommand(list []string, prod bool) Command {
+func NewComposeCommand(list []string, ctx ExecutionContext) Command {
 	name := "docker"
 
-	isProd := prod
-
-	if isProd {
-		if _, err := os.Stat("docker-compose.prod.yml"); os.IsNotExist(err) {
-			fmt.Printf("\n%s: The file 'docker-compose.prod.yml' does not exist.\n", color.YellowString("Warning"))
-			isProd = false
+	// the Compose file of the environment replaces the default override file
+	overrideFile := ctx.ComposeOverrideFile()
+	if overrideFile != "" {
+		if _, err := os.Stat(overrideFile); os.IsNotExist(err) {
+			// stderr: the output of the command may be the stdout of pliz (i.e. 'pliz db dump > dump.sql')
+			fmt.Fprintf(os.Stderr, "\n%s: The file '%s' does not exist.\n", color.YellowString("Warning"), overrideFile)
+			overrideFile = ""
 		}
 	}
 
 	args := []string{"compose"}
-	if isProd {
-		args = []string{"compose", "-f", "docker-compose.yml", "-f", "docker-compose.prod.yml"}
+	if overrideFile != "" {
+		args = []string{"compose", "-f", "docker-compose.yml", "-f", overrideFile}
 	}
 
 	args = append(args, list...)
@@ -119,7 +208,7 @@ func NewComposeCommand(list []string, prod bool) Command {
 	return Command{Name: name, Args: args}
 }
 
-func NewContainerCommand(container string, list []string, options []string, prod bool) Command {
+func NewContainerCommand(container string, list []string, options []string, ctx ExecutionContext) Command {
 	args := []string{"run", "--rm"}
 
 	// append the options
@@ -129,5 +218,43 @@ func NewContainerCommand(container string, list []string, options []string, prod
 	// and the command args
 	args = append(args, list...)
 
-	return NewComposeCommand(args, prod)
+	return NewComposeCommand(args, ctx)
+}
+
+// ContainerMode defines how a command is run in a Compose service
+type ContainerMode string
+
+const (
+	RunMode  ContainerMode = "run"  // in a new container ('docker compose run --rm')
+	ExecMode ContainerMode = "exec" // in the running container ('docker compose exec'), with a fallback to 'run'
+)
+
+// NewExecCommand creates a command executed in the running container of the service
+func NewExecCommand(container string, list []string, options []string, ctx ExecutionContext) Command {
+	args := []string{"exec"}
+
+	args = append(args, options...)
+	args = append(args, container)
+	args = append(args, list...)
+
+	return NewComposeCommand(args, ctx)
+}
+
+// NewServiceCommand creates a command executed in the service according to the mode.
+// With the 'exec' mode, a new container is used if the service is not running (a warning is written to out).
+func NewServiceCommand(container string, list []string, options []string, mode ContainerMode, ctx ExecutionContext, out io.Writer) Command {
+	if mode == ExecMode {
+		if IsServiceRunning(container, ctx) {
+			return NewExecCommand(container, list, options, ctx)
+		}
+		fmt.Fprintf(out, "%s: The service '%s' is not running, a new container is used.\n", color.YellowString("Warning"), container)
+	}
+
+	return NewContainerCommand(container, list, options, ctx)
+}
+
+// IsServiceRunning checks if a container of the Compose service is running
+func IsServiceRunning(container string, ctx ExecutionContext) bool {
+	containerID, err := NewComposeCommand([]string{"ps", "-q", container}, ctx).GetResult()
+	return err == nil && containerID != ""
 }
@@ -0,0 +1,91 @@
+package domain
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCommandString(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  Command
+		expected string
+	}{
+		{
+			name:     "without secret",
+			command:  Command{Name: "docker", Args: []string{"compose", "ps", "-q", "db"}},
+			expected: "docker compose ps -q db",
+		},
+		{
+			name:     "MySQL password in the env",
+			command:  Command{Name: "docker", Args: []string{"exec", "-i", "-e", "MYSQL_PWD", "abc", "mysqldump", "app"}, Env: []string{"MYSQL_PWD=s3cr3t"}},
+			expected: "MYSQL_PWD=**** docker exec -i -e MYSQL_PWD abc mysqldump app",
+		},
+		{
+			name:     "PostgreSQL password in the env",
+			command:  Command{Name: "docker", Args: []string{"exec", "-i", "-e", "PGPASSWORD", "abc", "pg_dump", "app"}, Env: []string{"PGPASSWORD=s3cr3t"}},
+			expected: "PGPASSWORD=**** docker exec -i -e PGPASSWORD abc pg_dump app",
+		},
+		{
+			name:     "password option",
+			command:  Command{Name: "docker", Args: []string{"exec", "abc", "mysqldump", "--user=root", "--password=s3cr3t", "app"}},
+			expected: "docker exec abc mysqldump --user=root --password=**** app",
+		},
+		{
+			name:     "quoted password",
+			command:  Command{Name: "sh", Args: []string{"-c", `mysql --password="s3 cr3t" app`}},
+			expected: `sh -c mysql --password=**** app`,
+		},
+		{
+			name:     "short password option of a MySQL client",
+			command:  Command{Name: "docker", Args: []string{"exec", "abc", "mysql", "-uroot", "-ps3cr3t", "app"}},
+			expected: "docker exec abc mysql -uroot -p**** app",
+		},
+		{
+			name:     "short password option of the MySQL client",
+			command:  Command{Name: "mysqldump", Args: []string{"-ps3cr3t"}},
+			expected: "mysqldump -p****",
+		},
+		{
+			name:     "'-p' option of another command",
+			command:  Command{Name: "docker", Args: []string{"run", "-p8080:80", "nginx"}},
+			expected: "docker run -p8080:80 nginx",
+		},
+		{
+			name:     "secret in the env of a task",
+			command:  Command{Name: "docker", Args: []string{"compose", "run", "-e", "API_TOKEN=abc123", "-e", "DEBUG=1", "app"}},
+			expected: "docker compose run -e API_TOKEN=**** -e DEBUG=1 app",
+		},
+		{
+			name:     "working directory",
+			command:  Command{Name: "make", Args: []string{"assets"}, Env: []string{"APP_KEY=base64:xyz"}, Dir: "front"},
+			expected: "APP_KEY=**** make assets (in front)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if line := test.command.String(); line != test.expected {
+				t.Errorf("expected '%s', got '%s'", test.expected, line)
+			}
+		})
+	}
+}
+
+func TestCommandDryRunRedacted(t *testing.T) {
+	SetDryRun(true)
+	defer SetDryRun(false)
+
+	var output bytes.Buffer
+	command := Command{Name: "docker", Args: []string{"exec", "abc", "mysqldump", "--password=s3cr3t"}, Env: []string{"MYSQL_PWD=s3cr3t"}}
+	if err := command.ExecuteWithOutput(&output, &output); err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(output.Bytes(), []byte("s3cr3t")) {
+		t.Errorf("the password is printed: %s", output.String())
+	}
+	if expected := "[dry-run] MYSQL_PWD=**** docker exec abc mysqldump --password=****\n"; output.String() != expected {
+		t.Errorf("expected %q, got %q", expected, output.String())
+	}
+}
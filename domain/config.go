@@ -6,11 +6,29 @@ type Config struct {
 	Containers                  ContainerConfig
 	ConfigFiles                 []ConfigFile
 	Tasks                       map[TaskID]Task
+	TaskGraph                   TaskGraph
 	Checklist                   []string
 
-	InstallTasks []TaskID // list of tasks that will be executed during install
+	InstallTasks      []TaskID             // list of tasks that will be executed during install
+	DefaultTasksCheck ExecutionCheckMethod // method used by the default tasks to know if they must be executed
 
 	BackupConfig Backup
+
+	Environments map[string]Environment // environments declared in pliz.yml
+}
+
+// Environment is the config of a pliz env (selected with '--env NAME')
+type Environment struct {
+	Protected bool // a confirmation is asked before the dangerous operations
+}
+
+// ExecutionContext returns the context of the env. An undeclared env is protected only if it's 'prod'.
+func (c Config) ExecutionContext(env string) ExecutionContext {
+	ctx := ExecutionContext{Env: env, Protected: env == "prod"}
+	if environment, ok := c.Environments[env]; ok {
+		ctx.Protected = environment.Protected
+	}
+	return ctx
 }
 
 type ConfigFile struct {
@@ -30,8 +48,11 @@ func (c ContainerConfig) All() []string {
 }
 
 type Backup struct {
-	Files     []string
-	Databases []DatabaseBackupConfig
+	Files      []string
+	Databases  []DatabaseBackupConfig
+	Recipients []string // public keys encrypting the backups (without password)
+
+	DefaultDatabase bool // 'backup.databases' is omitted: the db service is backed up if it's defined in the Compose files of the env
 }
 
 type DatabaseBackupConfig struct {
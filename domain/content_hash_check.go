@@ -0,0 +1,175 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// StateFile stores the data needed between two executions of pliz (i.e. the digests of the content hash checks)
+const StateFile = ".pliz/state"
+
+// prevent concurrent tasks to overwrite the state of each other
+var stateMutex sync.Mutex
+
+// ContentHashTaskExecutionCheck executes the task only if the content of the 'Files' (paths or glob patterns)
+// has changed since the last execution, or if the 'Target' doesn't exist.
+// The digest is stored in the state file with the key 'Key'.
+type ContentHashTaskExecutionCheck struct {
+	Key    string
+	Files  []string
+	Target string // optional
+}
+
+func (chk ContentHashTaskExecutionCheck) CanExecute() bool {
+	// without the files (or if they can't be read), the task is executed: it may create them
+	digest, err := chk.digest()
+	if err != nil {
+		fmt.Printf("%s: %v, the task is executed.\n", color.YellowString("Warning"), err)
+		return true
+	}
+
+	// if the 'Target' doesn't exist, then we consider we have to execute the task
+	if chk.Target != "" {
+		if _, err := os.Stat(chk.Target); os.IsNotExist(err) {
+			return true
+		}
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	state, err := readState()
+	if err != nil {
+		fmt.Println(err)
+		return true
+	}
+
+	return state[chk.Key] != digest
+}
+
+func (chk ContentHashTaskExecutionCheck) PostExecute() {
+	digest, err := chk.digest()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	state, err := readState()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	state[chk.Key] = digest
+
+	if err := writeState(state); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// digest computes a hash of the names and the contents of the files (the files of a matching directory are included)
+func (chk ContentHashTaskExecutionCheck) digest() (string, error) {
+	files := []string{}
+	for _, pattern := range chk.Files {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return "", err
+		}
+		for _, match := range matches {
+			matchFiles, err := regularFiles(match)
+			if err != nil {
+				return "", err
+			}
+			files = append(files, matchFiles...)
+		}
+	}
+
+	if len(files) == 0 {
+		return "", fmt.Errorf("No file found matching %v", chk.Files)
+	}
+	sort.Strings(files)
+
+	hash := sha256.New()
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+
+		fileHash := sha256.New()
+		_, err = io.Copy(fileHash, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(hash, "%s\x00%x\n", file, fileHash.Sum(nil))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// regularFiles returns the path if it's a file, or the files of the directory (the symlinks in the directory are not followed)
+func regularFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	files := []string{}
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, file)
+		}
+		return nil
+	})
+	return files, err
+}
+
+func readState() (map[string]string, error) {
+	state := map[string]string{}
+
+	data, err := ioutil.ReadFile(StateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("Unable to parse the state file '%s': %v", StateFile, err)
+	}
+
+	return state, nil
+}
+
+func writeState(state map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(StateFile), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(StateFile, data, 0644)
+}
@@ -0,0 +1,150 @@
+package domain
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// inTempDir runs the test in a new directory (the state file is relative to the current directory)
+func inTempDir(t *testing.T) {
+	dir := t.TempDir()
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(previous)
+	})
+}
+
+func writeFile(t *testing.T, name string, content string) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContentHashTaskExecutionCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		check    ContentHashTaskExecutionCheck
+		before   func(t *testing.T) // run before the first execution
+		change   func(t *testing.T) // run between the execution and the check
+		expected bool
+	}{
+		{
+			name:     "unchanged files",
+			check:    ContentHashTaskExecutionCheck{Key: "npm", Files: []string{"package.json"}},
+			expected: false,
+		},
+		{
+			name:     "modified file",
+			check:    ContentHashTaskExecutionCheck{Key: "npm", Files: []string{"package.json"}},
+			change:   func(t *testing.T) { writeFile(t, "package.json", `{"name": "changed"}`) },
+			expected: true,
+		},
+		{
+			name:     "new file matching the pattern",
+			check:    ContentHashTaskExecutionCheck{Key: "npm", Files: []string{"*.json"}},
+			change:   func(t *testing.T) { writeFile(t, "package-lock.json", "{}") },
+			expected: true,
+		},
+		{
+			name:     "file removed",
+			check:    ContentHashTaskExecutionCheck{Key: "npm", Files: []string{"*.json"}},
+			before:   func(t *testing.T) { writeFile(t, "package-lock.json", "{}") },
+			change:   func(t *testing.T) { os.Remove("package-lock.json") },
+			expected: true,
+		},
+		{
+			name:     "missing target",
+			check:    ContentHashTaskExecutionCheck{Key: "npm", Files: []string{"package.json"}, Target: "node_modules"},
+			before:   func(t *testing.T) { writeFile(t, "node_modules/.keep", "") },
+			change:   func(t *testing.T) { os.RemoveAll("node_modules") },
+			expected: true,
+		},
+		{
+			name:     "existing target",
+			check:    ContentHashTaskExecutionCheck{Key: "npm", Files: []string{"package.json"}, Target: "node_modules"},
+			before:   func(t *testing.T) { writeFile(t, "node_modules/.keep", "") },
+			expected: false,
+		},
+		{
+			name:     "digest of another task",
+			check:    ContentHashTaskExecutionCheck{Key: "npm", Files: []string{"package.json"}},
+			change:   func(t *testing.T) { writeState(map[string]string{"composer": "digest"}) },
+			expected: true,
+		},
+		{
+			name:     "unchanged directory matching the pattern",
+			check:    ContentHashTaskExecutionCheck{Key: "gulp", Files: []string{"src/*"}},
+			before:   func(t *testing.T) { writeFile(t, "src/js/app.js", "app"); writeFile(t, "src/main.css", "css") },
+			expected: false,
+		},
+		{
+			name:     "modified file in a directory matching the pattern",
+			check:    ContentHashTaskExecutionCheck{Key: "gulp", Files: []string{"src/*"}},
+			before:   func(t *testing.T) { writeFile(t, "src/js/app.js", "app"); writeFile(t, "src/main.css", "css") },
+			change:   func(t *testing.T) { writeFile(t, "src/js/app.js", "changed") },
+			expected: true,
+		},
+		{
+			name:     "new file in a directory",
+			check:    ContentHashTaskExecutionCheck{Key: "gulp", Files: []string{"src"}},
+			before:   func(t *testing.T) { writeFile(t, "src/js/app.js", "app") },
+			change:   func(t *testing.T) { writeFile(t, "src/js/vendor/lib.js", "lib") },
+			expected: true,
+		},
+		{
+			name:     "no file matching the pattern",
+			check:    ContentHashTaskExecutionCheck{Key: "npm", Files: []string{"missing.json"}},
+			expected: true,
+		},
+		{
+			name:     "invalid pattern",
+			check:    ContentHashTaskExecutionCheck{Key: "npm", Files: []string{"[.json"}},
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inTempDir(t)
+			writeFile(t, "package.json", `{"name": "test"}`)
+			if test.before != nil {
+				test.before(t)
+			}
+
+			if !test.check.CanExecute() {
+				t.Fatal("the task must be executed the first time")
+			}
+			test.check.PostExecute()
+
+			if test.change != nil {
+				test.change(t)
+			}
+
+			if executed := test.check.CanExecute(); executed != test.expected {
+				t.Errorf("expected CanExecute() = %v, got %v", test.expected, executed)
+			}
+		})
+	}
+}
+
+func TestContentHashTaskExecutionCheckInvalidState(t *testing.T) {
+	inTempDir(t)
+	writeFile(t, "package.json", "{}")
+	writeFile(t, StateFile, "not json")
+
+	check := ContentHashTaskExecutionCheck{Key: "npm", Files: []string{"package.json"}}
+	if !check.CanExecute() {
+		t.Error("the task must be executed if the state can't be read")
+	}
+}
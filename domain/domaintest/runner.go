@@ -0,0 +1,143 @@
+// Package domaintest provides a fake domain.Runner recording the executed commands,
+// allowing to test pliz without a Docker daemon.
+package domaintest
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"webup/pliz/domain"
+)
+
+// ExitError is returned by the RecordingRunner for a command stubbed with a non-zero exit code
+type ExitError int
+
+func (e ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func (e ExitError) ExitCode() int {
+	return int(e)
+}
+
+// RecordedCommand is a command executed through the RecordingRunner
+type RecordedCommand struct {
+	Argv  []string // name of the command followed by its args
+	Env   []string // additional environment variables (KEY=VALUE)
+	Dir   string   // working directory, empty for the current one
+	Stdin []byte   // content sent to the command (empty if the terminal stdin is used)
+}
+
+type stub struct {
+	prefix   []string
+	stdout   string
+	exitCode int
+}
+
+// RecordingRunner is a domain.Runner that doesn't execute anything.
+// Each command is recorded and answered with the first matching stub (empty output otherwise).
+type RecordingRunner struct {
+	mu       sync.Mutex
+	stubs    []stub
+	commands []RecordedCommand
+}
+
+// Install replaces the runner used by pliz with a new RecordingRunner until the end of the test
+func Install(t testing.TB) *RecordingRunner {
+	runner := &RecordingRunner{}
+	previous := domain.SetRunner(runner)
+	t.Cleanup(func() {
+		domain.SetRunner(previous)
+	})
+
+	return runner
+}
+
+// Stub defines the output and the exit code of the commands starting with argvPrefix
+func (r *RecordingRunner) Stub(argvPrefix []string, stdout string, exitCode int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stubs = append(r.stubs, stub{prefix: argvPrefix, stdout: stdout, exitCode: exitCode})
+}
+
+func (r *RecordingRunner) Run(c domain.Command, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	recorded := RecordedCommand{Argv: append([]string{c.Name}, c.Args...), Env: c.Env, Dir: c.Dir}
+
+	// don't wait for the user input
+	if stdin != nil && stdin != os.Stdin {
+		data, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		recorded.Stdin = data
+	}
+
+	r.mu.Lock()
+	r.commands = append(r.commands, recorded)
+	matched, found := r.match(recorded.Argv)
+	r.mu.Unlock()
+
+	if !found {
+		return nil
+	}
+
+	if stdout != nil {
+		if _, err := io.WriteString(stdout, matched.stdout); err != nil {
+			return err
+		}
+	}
+
+	if matched.exitCode != 0 {
+		return ExitError(matched.exitCode)
+	}
+
+	return nil
+}
+
+func (r *RecordingRunner) match(argv []string) (stub, bool) {
+	for _, s := range r.stubs {
+		if len(s.prefix) <= len(argv) && reflect.DeepEqual(s.prefix, argv[:len(s.prefix)]) {
+			return s, true
+		}
+	}
+
+	return stub{}, false
+}
+
+// Commands returns the recorded commands, in execution order
+func (r *RecordingRunner) Commands() []RecordedCommand {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]RecordedCommand{}, r.commands...)
+}
+
+// AssertCommands fails the test if the executed commands are not exactly the expected ones
+func (r *RecordingRunner) AssertCommands(t testing.TB, expected ...[]string) {
+	t.Helper()
+
+	commands := r.Commands()
+	actual := make([][]string, len(commands))
+	for i, cmd := range commands {
+		actual[i] = cmd.Argv
+	}
+
+	if len(actual) != len(expected) || (len(actual) > 0 && !reflect.DeepEqual(actual, expected)) {
+		t.Errorf("unexpected commands:\n%s\nexpected:\n%s", formatArgvs(actual), formatArgvs(expected))
+	}
+}
+
+func formatArgvs(argvs [][]string) string {
+	lines := make([]string, len(argvs))
+	for i, argv := range argvs {
+		lines[i] = "  " + strings.Join(argv, " ")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,31 @@
+package domain
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fatih/color"
+)
+
+var dryRun bool
+
+// SetDryRun enables or disables the dry-run mode: commands and file operations are printed instead of being performed
+func SetDryRun(enabled bool) {
+	dryRun = enabled
+}
+
+// IsDryRun indicates if the dry-run mode is enabled
+func IsDryRun() bool {
+	return dryRun
+}
+
+// PrintDryRun displays an operation that would have been performed without the dry-run mode
+func PrintDryRun(format string, a ...interface{}) {
+	FprintDryRun(os.Stdout, format, a...)
+}
+
+// FprintDryRun is like PrintDryRun but writes to w
+func FprintDryRun(w io.Writer, format string, a ...interface{}) {
+	fmt.Fprintf(w, "%s %s\n", color.CyanString("[dry-run]"), fmt.Sprintf(format, a...))
+}
@@ -11,6 +11,14 @@ type TaskExecutionCheck interface {
 	PostExecute()
 }
 
+// ExecutionCheckMethod is the method used by the default tasks to check if they must be executed
+type ExecutionCheckMethod string
+
+const (
+	ModificationDateCheckMethod ExecutionCheckMethod = "modification_date"
+	ContentHashCheckMethod      ExecutionCheckMethod = "content_hash"
+)
+
 type ModificationDateTaskExecutionCheck struct {
 	UpdatedFile string
 	CompareTo   string
@@ -45,3 +53,37 @@ func (chk ModificationDateTaskExecutionCheck) PostExecute() {
 	currentTime := time.Now().Local()
 	os.Chtimes(chk.CompareTo, currentTime, currentTime)
 }
+
+// MissingPathTaskExecutionCheck executes the task only if 'Path' doesn't exist
+type MissingPathTaskExecutionCheck struct {
+	Path string
+}
+
+func (chk MissingPathTaskExecutionCheck) CanExecute() bool {
+	_, err := os.Stat(chk.Path)
+	return os.IsNotExist(err)
+}
+
+func (chk MissingPathTaskExecutionCheck) PostExecute() {}
+
+// CommandTaskExecutionCheck executes the task only if the command (run on the host) exits with 0.
+// A shell command is given as ["sh", "-c", "..."].
+type CommandTaskExecutionCheck struct {
+	CommandArgs CommandArgs
+}
+
+func (chk CommandTaskExecutionCheck) CanExecute() bool {
+	_, err := NewCommand(chk.CommandArgs, false).GetResult()
+	return err == nil
+}
+
+func (chk CommandTaskExecutionCheck) PostExecute() {}
+
+func (chk CommandTaskExecutionCheck) checkCommand() Command {
+	return NewCommand(chk.CommandArgs, false)
+}
+
+// commandExecutionCheck is a check running a command: it's not evaluated in dry-run mode
+type commandExecutionCheck interface {
+	checkCommand() Command
+}
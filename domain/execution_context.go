@@ -1,9 +1,21 @@
 package domain
 
+import "fmt"
+
 type ExecutionContext struct {
-	Env string
+	Env       string // name of the environment, empty for the development one
+	Protected bool   // a confirmation is asked before the dangerous operations
+}
+
+// ComposeOverrideFile returns the Compose file of the environment (empty for the development one)
+func (ctx ExecutionContext) ComposeOverrideFile() string {
+	if ctx.Env == "" {
+		return ""
+	}
+	return fmt.Sprintf("docker-compose.%s.yml", ctx.Env)
 }
 
-func (ctx ExecutionContext) IsProd() bool {
-	return ctx.Env == "prod"
+// IsProtected indicates if a confirmation must be asked before the dangerous operations
+func (ctx ExecutionContext) IsProtected() bool {
+	return ctx.Protected
 }
@@ -0,0 +1,38 @@
+package domain
+
+import (
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Runner executes the commands built by pliz.
+// The default one uses os/exec, it can be replaced (i.e. by a fake in tests) using SetRunner.
+type Runner interface {
+	Run(c Command, stdin io.Reader, stdout io.Writer, stderr io.Writer) error
+}
+
+// ExecRunner runs the commands on the host using os/exec
+type ExecRunner struct{}
+
+func (ExecRunner) Run(c Command, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	cmd := exec.Command(c.Name, c.Args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Dir = c.Dir
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+
+	return cmd.Run()
+}
+
+var currentRunner Runner = ExecRunner{}
+
+// SetRunner replaces the runner used by every Command and returns the previous one
+func SetRunner(runner Runner) Runner {
+	previous := currentRunner
+	currentRunner = runner
+	return previous
+}
@@ -2,17 +2,39 @@ package domain
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"sort"
 	"strings"
 )
 
 type TaskID string
 
+// ArgsPlaceholder can be used in the command of a task to set where the extra args must be inserted
+const ArgsPlaceholder = "{{args}}"
+
+// TaskOrigin indicates where a task has been defined
+type TaskOrigin string
+
+const (
+	DefaultTaskOrigin    TaskOrigin = "built-in"   // a default task of pliz
+	OverriddenTaskOrigin TaskOrigin = "overridden" // a default task overridden in pliz.yml
+	CustomTaskOrigin     TaskOrigin = "custom"     // a task defined in pliz.yml
+)
+
 type Task struct {
 	Name           TaskID
+	Origin         TaskOrigin
 	Description    string
 	Container      *string
+	Mode           ContainerMode // 'run' if empty
 	ExecutionCheck TaskExecutionCheck
 	CommandArgs    CommandArgs
+	Dependencies   []TaskID // tasks to execute before this one
+
+	Env        map[string]string // additional environment variables
+	WorkingDir string
+	User       string
 }
 
 func DefaultTaskNames() []TaskID {
@@ -25,26 +47,121 @@ func DefaultTaskNames() []TaskID {
 	}
 }
 
-func (t Task) Execute(context TaskExecutionContext) bool {
-	if t.ExecutionCheck != nil && !t.ExecutionCheck.CanExecute() {
-		// return errors.New(fmt.Sprintf("Task '%s' skipped.", t.Name))
-		fmt.Printf("Task '%s' skipped.\n", t.Name)
-		return false
+// Execute runs the task. It returns false if the task has been skipped by its execution check,
+// and an error if the command failed.
+func (t Task) Execute(context TaskExecutionContext) (bool, error) {
+	stdout := context.stdout()
+
+	// the command of a check is not run in dry-run mode: it may have side effects
+	commandCheck, isCommandCheck := t.ExecutionCheck.(commandExecutionCheck)
+	if dryRun && isCommandCheck {
+		FprintDryRun(stdout, "Task '%s' would check '%s', it would be executed if it exits with 0", t.Name, commandCheck.checkCommand())
+	} else if t.ExecutionCheck != nil && !t.ExecutionCheck.CanExecute() {
+		if dryRun {
+			FprintDryRun(stdout, "Task '%s' is up to date, it would be skipped", t.Name)
+			return false, nil
+		}
+		fmt.Fprintf(stdout, "Task '%s' skipped.\n", t.Name)
+		return false, nil
+	}
+
+	if dryRun && !isCommandCheck {
+		if t.ExecutionCheck != nil {
+			FprintDryRun(stdout, "Task '%s' is outdated, it would be executed", t.Name)
+		} else {
+			FprintDryRun(stdout, "Task '%s' has no execution check, it would be executed", t.Name)
+		}
+	}
+
+	options := []string{}
+	if context.Stdout != nil {
+		// the output is not a terminal: disable the pseudo-TTY allocation
+		options = append(options, "-T")
+	}
+
+	commandArgs := t.commandArgsWith(context.Args)
+
+	// sort the env variables to always build the same command
+	envNames := []string{}
+	for name := range t.Env {
+		envNames = append(envNames, name)
+	}
+	sort.Strings(envNames)
+	env := []string{}
+	// the name of the pliz env is given to the task
+	if context.Env != "" {
+		env = append(env, fmt.Sprintf("PLIZ_ENV=%s", context.Env))
+	}
+	for _, name := range envNames {
+		env = append(env, fmt.Sprintf("%s=%s", name, t.Env[name]))
 	}
 
 	var command Command
 	if t.Container != nil {
-		command = NewContainerCommand(*t.Container, t.CommandArgs, []string{}, context.Prod)
+		for _, variable := range env {
+			options = append(options, "-e", variable)
+		}
+		if t.WorkingDir != "" {
+			options = append(options, "-w", t.WorkingDir)
+		}
+		if t.User != "" {
+			options = append(options, "-u", t.User)
+		}
+
+		command = NewServiceCommand(*t.Container, commandArgs, options, t.Mode, context.ExecutionContext, stdout)
+	} else {
+		// on the host, the command is run with sudo to change the user.
+		// sudo resets the environment: the variables are given with 'env'
+		if t.User != "" {
+			prefix := CommandArgs{"sudo", "-u", t.User, "--"}
+			if len(env) > 0 {
+				prefix = append(append(prefix, "env"), env...)
+			}
+			commandArgs = append(prefix, commandArgs...)
+		}
+
+		command = NewCommand(commandArgs, true)
+		if t.User == "" {
+			command.Env = env
+		}
+		command.Dir = t.WorkingDir
+	}
+
+	var err error
+	if context.Stdout != nil {
+		err = command.ExecuteWithOutput(stdout, context.stderr())
 	} else {
-		command = NewCommand(t.CommandArgs, true)
+		err = command.Execute()
+	}
+	if err != nil {
+		return true, fmt.Errorf("Task '%s' failed: %w", t.Name, err)
 	}
-	command.Execute()
 
-	if t.ExecutionCheck != nil {
+	if t.ExecutionCheck != nil && !dryRun {
 		t.ExecutionCheck.PostExecute()
 	}
 
-	return true
+	return true, nil
+}
+
+// commandArgsWith inserts the extra args in place of the placeholder, or appends them if there is no placeholder
+func (t Task) commandArgsWith(args []string) CommandArgs {
+	commandArgs := CommandArgs{}
+	replaced := false
+	for _, arg := range t.CommandArgs {
+		if arg == ArgsPlaceholder {
+			commandArgs = append(commandArgs, args...)
+			replaced = true
+		} else {
+			commandArgs = append(commandArgs, arg)
+		}
+	}
+
+	if !replaced {
+		commandArgs = append(commandArgs, args...)
+	}
+
+	return commandArgs
 }
 
 func (t Task) String() string {
@@ -52,5 +169,25 @@ func (t Task) String() string {
 }
 
 type TaskExecutionContext struct {
-	Prod bool
+	ExecutionContext
+	Args []string // extra args given to the command of the task
+
+	// optional. Allows to redirect the output of the task (i.e. during a parallel execution).
+	// When set, the task doesn't read the standard input.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+func (ctx TaskExecutionContext) stdout() io.Writer {
+	if ctx.Stdout != nil {
+		return ctx.Stdout
+	}
+	return os.Stdout
+}
+
+func (ctx TaskExecutionContext) stderr() io.Writer {
+	if ctx.Stderr != nil {
+		return ctx.Stderr
+	}
+	return ctx.stdout()
 }
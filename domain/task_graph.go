@@ -0,0 +1,97 @@
+package domain
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TaskGraph holds the dependencies of each task (declared with 'depends_on')
+type TaskGraph map[TaskID][]TaskID
+
+// NewTaskGraph builds the dependency graph of the tasks.
+// An error is returned if a dependency is unknown or if there is a cycle.
+func NewTaskGraph(tasks map[TaskID]Task) (TaskGraph, error) {
+	graph := TaskGraph{}
+
+	ids := []string{}
+	for id, task := range tasks {
+		for _, dependency := range task.Dependencies {
+			if _, ok := tasks[dependency]; !ok {
+				return nil, fmt.Errorf("Task '%s' depends on '%s' which is not available", id, dependency)
+			}
+		}
+		graph[id] = task.Dependencies
+		ids = append(ids, string(id))
+	}
+
+	// sort the ids to always report the same cycle
+	sort.Strings(ids)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	states := map[TaskID]int{}
+
+	var visit func(id TaskID, path []TaskID) error
+	visit = func(id TaskID, path []TaskID) error {
+		path = append(path, id)
+
+		switch states[id] {
+		case visited:
+			return nil
+		case visiting:
+			names := []string{}
+			for _, item := range path {
+				names = append(names, string(item))
+			}
+			return fmt.Errorf("Tasks dependency cycle: %s", strings.Join(names, " -> "))
+		}
+
+		states[id] = visiting
+		for _, dependency := range graph[id] {
+			if err := visit(dependency, path); err != nil {
+				return err
+			}
+		}
+		states[id] = visited
+
+		return nil
+	}
+
+	for _, id := range ids {
+		if err := visit(TaskID(id), []TaskID{}); err != nil {
+			return nil, err
+		}
+	}
+
+	return graph, nil
+}
+
+// ExecutionOrder returns the tasks to execute to run the given tasks: the dependencies come first
+// and each task appears only once. The order of the given tasks is kept when possible.
+func (g TaskGraph) ExecutionOrder(ids ...TaskID) []TaskID {
+	order := []TaskID{}
+	added := map[TaskID]bool{}
+
+	var add func(id TaskID)
+	add = func(id TaskID) {
+		if added[id] {
+			return
+		}
+		added[id] = true
+
+		for _, dependency := range g[id] {
+			add(dependency)
+		}
+		order = append(order, id)
+	}
+
+	for _, id := range ids {
+		add(id)
+	}
+
+	return order
+}
@@ -0,0 +1,110 @@
+package domain
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func tasksWithDependencies(dependencies map[TaskID][]TaskID) map[TaskID]Task {
+	tasks := map[TaskID]Task{}
+	for id, taskDependencies := range dependencies {
+		tasks[id] = Task{Name: id, Dependencies: taskDependencies}
+	}
+	return tasks
+}
+
+func TestNewTaskGraph(t *testing.T) {
+	tests := []struct {
+		name         string
+		dependencies map[TaskID][]TaskID
+		err          string
+	}{
+		{
+			name:         "no dependencies",
+			dependencies: map[TaskID][]TaskID{"a": nil, "b": nil},
+		},
+		{
+			name:         "chain",
+			dependencies: map[TaskID][]TaskID{"a": {"b"}, "b": {"c"}, "c": nil},
+		},
+		{
+			name:         "diamond",
+			dependencies: map[TaskID][]TaskID{"a": {"b", "c"}, "b": {"d"}, "c": {"d"}, "d": nil},
+		},
+		{
+			name:         "unknown dependency",
+			dependencies: map[TaskID][]TaskID{"a": {"missing"}},
+			err:          "Task 'a' depends on 'missing' which is not available",
+		},
+		{
+			name:         "self dependency",
+			dependencies: map[TaskID][]TaskID{"a": {"a"}},
+			err:          "Tasks dependency cycle: a -> a",
+		},
+		{
+			name:         "cycle",
+			dependencies: map[TaskID][]TaskID{"a": {"b"}, "b": {"c"}, "c": {"a"}, "d": nil},
+			err:          "Tasks dependency cycle: a -> b -> c -> a",
+		},
+		{
+			name:         "cycle reached from another task",
+			dependencies: map[TaskID][]TaskID{"a": {"x"}, "x": {"y"}, "y": {"x"}},
+			err:          "Tasks dependency cycle: a -> x -> y -> x",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			graph, err := NewTaskGraph(tasksWithDependencies(test.dependencies))
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected the error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for id, dependencies := range test.dependencies {
+				if !reflect.DeepEqual(graph[id], dependencies) {
+					t.Errorf("dependencies of '%s': expected %v, got %v", id, dependencies, graph[id])
+				}
+			}
+		})
+	}
+}
+
+func TestTaskGraphExecutionOrder(t *testing.T) {
+	graph := TaskGraph{
+		"install":  {"composer", "npm"},
+		"composer": nil,
+		"npm":      nil,
+		"gulp":     {"npm"},
+		"deploy":   {"gulp", "install"},
+	}
+
+	tests := []struct {
+		name     string
+		ids      []TaskID
+		expected []TaskID
+	}{
+		{"single task", []TaskID{"npm"}, []TaskID{"npm"}},
+		{"dependencies first", []TaskID{"gulp"}, []TaskID{"npm", "gulp"}},
+		{"order of the dependencies kept", []TaskID{"install"}, []TaskID{"composer", "npm", "install"}},
+		{"each task once", []TaskID{"deploy"}, []TaskID{"npm", "gulp", "composer", "install", "deploy"}},
+		{"order of the given tasks kept", []TaskID{"npm", "composer"}, []TaskID{"npm", "composer"}},
+		{"task given twice", []TaskID{"gulp", "npm", "gulp"}, []TaskID{"npm", "gulp"}},
+		{"no task", []TaskID{}, []TaskID{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			order := graph.ExecutionOrder(test.ids...)
+			if !reflect.DeepEqual(order, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, order)
+			}
+		})
+	}
+}
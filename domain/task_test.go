@@ -0,0 +1,218 @@
+package domain_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"webup/pliz/domain"
+	"webup/pliz/domain/domaintest"
+)
+
+func container(name string) *string {
+	return &name
+}
+
+func TestTaskExecuteCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		task     domain.Task
+		env      string
+		args     []string
+		stubs    map[string]string // output of the commands starting with the key (split on spaces)
+		expected [][]string
+		output   string // expected in the output of the task
+
+		commandEnv []string // environment of the last command
+		commandDir string   // working directory of the last command
+	}{
+		{
+			name:     "container",
+			task:     domain.Task{Name: "npm", Container: container("srcbuild"), CommandArgs: domain.CommandArgs{"npm", "install"}},
+			expected: [][]string{{"docker", "compose", "run", "--rm", "-T", "srcbuild", "npm", "install"}},
+		},
+		{
+			name: "container with env, workdir and user",
+			task: domain.Task{
+				Name: "migrate", Container: container("app"), CommandArgs: domain.CommandArgs{"php", "artisan", "migrate"},
+				Env: map[string]string{"B": "2", "A": "1"}, WorkingDir: "/app", User: "www-data",
+			},
+			expected: [][]string{{"docker", "compose", "run", "--rm", "-T", "-e", "A=1", "-e", "B=2", "-w", "/app", "-u", "www-data", "app", "php", "artisan", "migrate"}},
+		},
+		{
+			name:     "env without Compose file",
+			task:     domain.Task{Name: "npm", Container: container("srcbuild"), CommandArgs: domain.CommandArgs{"npm", "install"}},
+			env:      "staging",
+			expected: [][]string{{"docker", "compose", "run", "--rm", "-T", "-e", "PLIZ_ENV=staging", "srcbuild", "npm", "install"}},
+		},
+		{
+			name:     "extra args",
+			task:     domain.Task{Name: "artisan", Container: container("app"), CommandArgs: domain.CommandArgs{"php", "artisan", domain.ArgsPlaceholder, "--no-interaction"}},
+			args:     []string{"migrate:fresh", "--seed"},
+			expected: [][]string{{"docker", "compose", "run", "--rm", "-T", "app", "php", "artisan", "migrate:fresh", "--seed", "--no-interaction"}},
+		},
+		{
+			name:  "exec mode in the running service",
+			task:  domain.Task{Name: "cache", Container: container("app"), Mode: domain.ExecMode, CommandArgs: domain.CommandArgs{"php", "artisan", "cache:clear"}},
+			stubs: map[string]string{"docker compose ps -q app": "abc123"},
+			expected: [][]string{
+				{"docker", "compose", "ps", "-q", "app"},
+				{"docker", "compose", "exec", "-T", "app", "php", "artisan", "cache:clear"},
+			},
+		},
+		{
+			name: "exec mode in a stopped service",
+			task: domain.Task{Name: "cache", Container: container("app"), Mode: domain.ExecMode, CommandArgs: domain.CommandArgs{"php", "artisan", "cache:clear"}},
+			expected: [][]string{
+				{"docker", "compose", "ps", "-q", "app"},
+				{"docker", "compose", "run", "--rm", "-T", "app", "php", "artisan", "cache:clear"},
+			},
+			output: "The service 'app' is not running, a new container is used",
+		},
+		{
+			name:       "host",
+			task:       domain.Task{Name: "assets", CommandArgs: domain.CommandArgs{"make", "assets"}, Env: map[string]string{"B": "2", "A": "1"}},
+			expected:   [][]string{{"make", "assets"}},
+			commandEnv: []string{"A=1", "B=2"},
+		},
+		{
+			name:       "host with env and workdir",
+			task:       domain.Task{Name: "assets", CommandArgs: domain.CommandArgs{"make", "assets"}, Env: map[string]string{"A": "1"}, WorkingDir: "front"},
+			env:        "prod",
+			expected:   [][]string{{"make", "assets"}},
+			commandEnv: []string{"PLIZ_ENV=prod", "A=1"},
+			commandDir: "front",
+		},
+		{
+			name:     "host with a user",
+			task:     domain.Task{Name: "assets", CommandArgs: domain.CommandArgs{"make", "assets"}, Env: map[string]string{"A": "1"}, User: "deploy"},
+			env:      "prod",
+			expected: [][]string{{"sudo", "-u", "deploy", "--", "env", "PLIZ_ENV=prod", "A=1", "make", "assets"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runner := domaintest.Install(t)
+			for prefix, output := range test.stubs {
+				runner.Stub(strings.Split(prefix, " "), output, 0)
+			}
+
+			var output bytes.Buffer
+			ctx := domain.TaskExecutionContext{ExecutionContext: domain.ExecutionContext{Env: test.env}, Args: test.args, Stdout: &output}
+			executed, err := test.task.Execute(ctx)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !executed {
+				t.Error("the task must be executed")
+			}
+
+			runner.AssertCommands(t, test.expected...)
+			commands := runner.Commands()
+			last := commands[len(commands)-1]
+			if !reflect.DeepEqual(last.Env, test.commandEnv) || last.Dir != test.commandDir {
+				t.Errorf("expected the env %q in '%s', got %q in '%s'", test.commandEnv, test.commandDir, last.Env, last.Dir)
+			}
+			if !strings.Contains(output.String(), test.output) {
+				t.Errorf("expected %q in the output:\n%s", test.output, output.String())
+			}
+		})
+	}
+}
+
+func TestTaskExecuteWithEnvComposeFile(t *testing.T) {
+	dir := t.TempDir()
+	previous, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(previous)
+	if err := ioutil.WriteFile("docker-compose.prod.yml", []byte("services: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := domaintest.Install(t)
+	task := domain.Task{Name: "npm", Container: container("srcbuild"), CommandArgs: domain.CommandArgs{"npm", "install"}}
+	ctx := domain.TaskExecutionContext{ExecutionContext: domain.ExecutionContext{Env: "prod"}, Stdout: ioutil.Discard}
+	if _, err := task.Execute(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	runner.AssertCommands(t, []string{"docker", "compose", "-f", "docker-compose.yml", "-f", "docker-compose.prod.yml", "run", "--rm", "-T", "-e", "PLIZ_ENV=prod", "srcbuild", "npm", "install"})
+}
+
+func TestTaskExecuteFailure(t *testing.T) {
+	runner := domaintest.Install(t)
+	runner.Stub([]string{"docker", "compose", "run"}, "", 3)
+
+	task := domain.Task{Name: "npm", Container: container("srcbuild"), CommandArgs: domain.CommandArgs{"npm", "install"}}
+	_, err := task.Execute(domain.TaskExecutionContext{Stdout: ioutil.Discard})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if code := domain.ExitCode(err); code != 3 {
+		t.Errorf("expected the exit code 3, got %d (%v)", code, err)
+	}
+}
+
+func TestTaskExecuteDryRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		task     domain.Task
+		expected [][]string // only the queries are run in dry-run mode
+		output   string
+	}{
+		{
+			name:   "container",
+			task:   domain.Task{Name: "npm", Container: container("srcbuild"), CommandArgs: domain.CommandArgs{"npm", "install"}},
+			output: "[dry-run] docker compose run --rm -T srcbuild npm install",
+		},
+		{
+			name:     "exec mode",
+			task:     domain.Task{Name: "cache", Container: container("app"), Mode: domain.ExecMode, CommandArgs: domain.CommandArgs{"php", "artisan", "cache:clear"}},
+			expected: [][]string{{"docker", "compose", "ps", "-q", "app"}},
+			output:   "[dry-run] docker compose exec -T app php artisan cache:clear",
+		},
+		{
+			name:   "host with a user",
+			task:   domain.Task{Name: "assets", CommandArgs: domain.CommandArgs{"make"}, User: "deploy", WorkingDir: "/srv"},
+			output: "[dry-run] sudo -u deploy -- make (in /srv)",
+		},
+		{
+			name:   "secrets redacted",
+			task:   domain.Task{Name: "seed", CommandArgs: domain.CommandArgs{"seed", "--password=secret"}, Env: map[string]string{"API_TOKEN": "abc"}},
+			output: "[dry-run] API_TOKEN=**** seed --password=****",
+		},
+		{
+			name: "command check not run",
+			task: domain.Task{
+				Name: "key", CommandArgs: domain.CommandArgs{"php", "artisan", "key:generate"},
+				ExecutionCheck: &domain.CommandTaskExecutionCheck{CommandArgs: domain.CommandArgs{"sh", "-c", "! test -s .env"}},
+			},
+			output: "[dry-run] Task 'key' would check 'sh -c ! test -s .env', it would be executed if it exits with 0\n" +
+				"[dry-run] php artisan key:generate",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runner := domaintest.Install(t)
+			runner.Stub([]string{"docker", "compose", "ps", "-q", "app"}, "abc123", 0)
+			domain.SetDryRun(true)
+			defer domain.SetDryRun(false)
+
+			var output bytes.Buffer
+			if _, err := test.task.Execute(domain.TaskExecutionContext{Stdout: &output}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			runner.AssertCommands(t, test.expected...)
+			if !strings.Contains(output.String(), test.output) {
+				t.Errorf("expected %q in the output:\n%s", test.output, output.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,4 @@
+package domain
+
+// Version of pliz
+const Version = "16"
@@ -6,10 +6,9 @@ require (
 	github.com/Songmu/prompter v0.0.0-20150727040349-f49666b0047d
 	github.com/fatih/color v0.0.0-20160317093153-533cd7fd8a85
 	github.com/jawher/mow.cli v0.0.0-20160221171641-772320464101
-	github.com/jhoonb/archivex v0.0.0-20160315200532-333107c71422
 	github.com/mattn/go-colorable v0.1.8 // indirect
 	github.com/stretchr/testify v1.7.0 // indirect
-	golang.org/x/crypto v0.0.0-20160419125735-2f6fccd33b9b // indirect
+	golang.org/x/crypto v0.0.0-20160419125735-2f6fccd33b9b
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
@@ -5,6 +5,7 @@ import (
 	"os"
 	"regexp"
 	"sort"
+	"strings"
 
 	"webup/pliz/actions"
 	"webup/pliz/config"
@@ -20,34 +21,48 @@ func main() {
 
 	app := cli.App("pliz", "Manage projects building")
 
-	app.Version("v version", "Pliz 16")
+	app.Version("v version", "Pliz "+domain.Version)
 
 	// option to change the Pliz env
 	plizEnv := app.String(cli.StringOpt{
-		Name:  "env",
-		Value: "",
-		Desc:  "Change the environnment of Pliz (i.e. 'prod'). The environment var 'PLIZ_ENV' can be use too.",
+		Name:   "env",
+		Value:  "",
+		Desc:   "Change the environnment of Pliz (i.e. 'prod', 'staging'...). The environment var 'PLIZ_ENV' can be use too.",
+		EnvVar: "PLIZ_ENV",
+	})
+	// option to only display what would be done
+	dryRun := app.Bool(cli.BoolOpt{
+		Name:  "dry-run",
+		Value: false,
+		Desc:  "Print the commands and the file operations instead of executing them",
 	})
-	prod := false
 	var executionContext domain.ExecutionContext
+	// disabled by the commands handling the errors of the config themselves
+	checkConfig := true
 
 	app.Before = func() {
-		// Parse and check config
-		parseAndCheckConfig()
+		// an undeclared env is protected only if it's 'prod'
+		executionContext = domain.Config{}.ExecutionContext(*plizEnv)
 
-		// check for env
-		if *plizEnv == "prod" || os.Getenv("PLIZ_ENV") == "prod" {
-			prod = true
+		// Parse and check config
+		if checkConfig {
+			parseAndCheckConfig(executionContext)
+			// the protection of the env is set in the config
+			executionContext = config.Get().ExecutionContext(*plizEnv)
 		}
 
-		executionContext = domain.ExecutionContext{Env: *plizEnv}
+		domain.SetDryRun(*dryRun)
 	}
 
 	app.Command("start", "Start (or restart) the project", func(cmd *cli.Cmd) {
 		cmd.Action = func() {
-			actions.StartActionHandler(prod, true)
+			err := actions.StartActionHandler(executionContext, true)
+			if err != nil {
+				fmt.Printf("\n%s: %v\n", color.RedString("Unable to start the project"), err)
+				cli.Exit(domain.ExitCode(err))
+			}
 
-			if !prod {
+			if !executionContext.IsProtected() {
 				// display access infos
 				containerID, _ := utils.GetContainerID(config.Get().StartupContainer, executionContext)
 				ports := utils.GetExposedPorts(containerID, executionContext)
@@ -73,8 +88,11 @@ func main() {
 
 	app.Command("stop", "Stop the project", func(cmd *cli.Cmd) {
 		cmd.Action = func() {
-			cmd := domain.NewComposeCommand([]string{"stop"}, prod)
-			cmd.Execute()
+			cmd := domain.NewComposeCommand([]string{"stop"}, executionContext)
+			if err := cmd.Execute(); err != nil {
+				fmt.Printf("\n%s: %v\n", color.RedString("Unable to stop the project"), err)
+				cli.Exit(domain.ExitCode(err))
+			}
 		}
 	})
 
@@ -88,13 +106,19 @@ func main() {
 			EnvVar: "PLIZ_INSTALL_SKIP",
 		})
 		// cmd.StringsOpt("skip", []string{}, "")
+		jobs := cmd.Int(cli.IntOpt{
+			Name:   "j jobs",
+			Value:  1,
+			Desc:   "Number of tasks executed concurrently (only the dependencies declared with 'depends_on' are waited)",
+			EnvVar: "PLIZ_INSTALL_JOBS",
+		})
 
 		cmd.Action = func() {
 
 			config := config.Get()
 
-			if prod {
-				backup := prompter.YN("You're in production. Do you want to make a backup?", true)
+			if executionContext.IsProtected() {
+				backup := prompter.YN(fmt.Sprintf("You're in the protected environment '%s'. Do you want to make a backup?", executionContext.Env), true)
 				if backup {
 					err := actions.BackupActionHandler(executionContext, nil, nil, nil, nil, false)
 					if err != nil {
@@ -125,14 +149,21 @@ func main() {
 
 				// check if the file exists. If not, duplicate the sample
 				if _, err := os.Stat(configFile.Target); os.IsNotExist(err) {
-					utils.CopyFileContents(configFile.Sample, configFile.Target)
+					if domain.IsDryRun() {
+						domain.PrintDryRun("Copy %s to %s", configFile.Sample, configFile.Target)
+					} else {
+						utils.CopyFileContents(configFile.Sample, configFile.Target)
+					}
 					created = true
 				}
 
 				// edit the file
 				if created {
 					cmd := domain.NewCommand([]string{"vim", configFile.Target}, true)
-					cmd.Execute()
+					if err := cmd.Execute(); err != nil {
+						fmt.Printf("\n%s: %v\n", color.RedString("Unable to edit the config file"), err)
+						cli.Exit(domain.ExitCode(err))
+					}
 				}
 
 				fmt.Println(configFile.Target + color.GreenString(" OK."))
@@ -146,8 +177,11 @@ func main() {
 
 			fmt.Printf("\n %s ️ Build the containers...\n", color.YellowString("▶"))
 
-			cmd := domain.NewComposeCommand([]string{"build"}, prod)
-			cmd.Execute()
+			cmd := domain.NewComposeCommand([]string{"build"}, executionContext)
+			if err := cmd.Execute(); err != nil {
+				fmt.Printf("\n%s: %v\n", color.RedString("Unable to build the containers"), err)
+				cli.Exit(domain.ExitCode(err))
+			}
 
 			fmt.Println("")
 
@@ -158,7 +192,10 @@ func main() {
 			fmt.Printf("\n %s ️ Starting containers...\n", color.YellowString("▶"))
 
 			// and start the containers
-			actions.StartActionHandler(prod, false)
+			if err := actions.StartActionHandler(executionContext, false); err != nil {
+				fmt.Printf("\n%s: %v\n", color.RedString("Unable to start the containers"), err)
+				cli.Exit(domain.ExitCode(err))
+			}
 
 			fmt.Println("")
 
@@ -168,28 +205,45 @@ func main() {
 
 			fmt.Printf("\n %s ️ Run install tasks...\n", color.YellowString("▶"))
 
-		TaskLoop:
-			for _, id := range config.InstallTasks {
-
-				task := config.Tasks[id]
+			// the dependencies of the install tasks are executed first
+			installTasks := config.TaskGraph.ExecutionOrder(config.InstallTasks...)
 
-				// check if the task is skipped
-				for _, taskName := range *skipped {
-					if taskName == string(task.Name) {
-						fmt.Printf("\n%s %s %s\n", color.YellowString("-->"), task.Name, color.YellowString("skipped"))
-						continue TaskLoop
-					}
+			if *jobs > 1 {
+				fmt.Println("")
+				err := actions.ParallelTasksActionHandler(installTasks, *skipped, *forced, *jobs, executionContext)
+				if err != nil {
+					fmt.Printf("\n%s: %v\n", color.RedString("Installation aborted"), err)
+					cli.Exit(domain.ExitCode(err))
 				}
+			} else {
+			TaskLoop:
+				for _, id := range installTasks {
+
+					task := config.Tasks[id]
+
+					// check if the task is skipped
+					for _, taskName := range *skipped {
+						if taskName == string(task.Name) {
+							fmt.Printf("\n%s %s %s\n", color.YellowString("-->"), task.Name, color.YellowString("skipped"))
+							continue TaskLoop
+						}
+					}
 
-				fmt.Printf("\n%s %s %s\n", color.CyanString("***"), task.Name, color.CyanString("***"))
+					fmt.Printf("\n%s %s %s\n", color.CyanString("***"), task.Name, color.CyanString("***"))
 
-				// disable the execution check if the installation is forced
-				if *forced {
-					task.ExecutionCheck = nil
-				}
+					// disable the execution check if the installation is forced
+					if *forced {
+						task.ExecutionCheck = nil
+					}
 
-				if task.Execute(domain.TaskExecutionContext{Prod: prod}) {
-					fmt.Printf("Task '%s' %s.\n", task.Name, color.GreenString("executed"))
+					executed, err := task.Execute(domain.TaskExecutionContext{ExecutionContext: executionContext})
+					if err != nil {
+						fmt.Printf("\n%s: %v\n", color.RedString("Installation aborted"), err)
+						cli.Exit(domain.ExitCode(err))
+					}
+					if executed && !domain.IsDryRun() {
+						fmt.Printf("Task '%s' %s.\n", task.Name, color.GreenString("executed"))
+					}
 				}
 			}
 
@@ -212,15 +266,16 @@ func main() {
 
 	app.Command("bash", "Display a shell inside the builder service (or the specified service)", func(cmd *cli.Cmd) {
 
-		// parse and check config
-		parseAndCheckConfig()
+		// parse the config (checked before the execution of the command)
+		loadConfig()
 
 		defaultContainer := config.Get().Containers.Builder
 
-		cmd.Spec = "[-p...] [SERVICE]"
+		cmd.Spec = "[-p... | --exec] [SERVICE]"
 
 		container := cmd.StringArg("SERVICE", defaultContainer, "The Compose service that will be used to display the shell")
 		ports := cmd.StringsOpt("p port", []string{}, "List of ports that will be published (e.g. 9000:3306)")
+		exec := cmd.BoolOpt("exec", false, "Use the running container of the service (a new one is used if the service is not running)")
 
 		cmd.Action = func() {
 
@@ -231,8 +286,30 @@ func main() {
 				}
 			}
 
-			cmd := domain.NewContainerCommand(*container, []string{"bash"}, options, prod)
-			cmd.Execute()
+			mode := domain.RunMode
+			if *exec {
+				mode = domain.ExecMode
+			}
+
+			cmd := domain.NewServiceCommand(*container, []string{"bash"}, options, mode, executionContext, os.Stdout)
+			if err := cmd.Execute(); err != nil {
+				cli.Exit(domain.ExitCode(err))
+			}
+		}
+	})
+
+	app.Command("exec", "Execute a command inside the running container of a service (a new container is used if the service is not running)", func(cmd *cli.Cmd) {
+
+		cmd.Spec = "SERVICE CMD..."
+
+		container := cmd.StringArg("SERVICE", "", "The Compose service in which the command will be executed")
+		command := cmd.StringsArg("CMD", []string{}, "The command to execute (use '--' before the command if it has some options)")
+
+		cmd.Action = func() {
+			cmd := domain.NewServiceCommand(*container, *command, []string{}, domain.ExecMode, executionContext, os.Stdout)
+			if err := cmd.Execute(); err != nil {
+				cli.Exit(domain.ExitCode(err))
+			}
 		}
 	})
 
@@ -249,15 +326,17 @@ func main() {
 				args = append(args, *container)
 			}
 
-			cmd := domain.NewComposeCommand(args, prod)
-			cmd.Execute()
+			cmd := domain.NewComposeCommand(args, executionContext)
+			if err := cmd.Execute(); err != nil {
+				cli.Exit(domain.ExitCode(err))
+			}
 		}
 	})
 
 	app.Command("run", "Execute a single task", func(cmd *cli.Cmd) {
 
-		// parse and check config
-		parseAndCheckConfig()
+		// parse the config (checked before the execution of the command)
+		loadConfig()
 
 		taskIDs := []string{}
 		for id := range config.Get().Tasks {
@@ -269,12 +348,75 @@ func main() {
 			task := config.Get().Tasks[domain.TaskID(id)]
 
 			cmd.Command(id, task.Description, func(cmd *cli.Cmd) {
-				cmd.Action = actions.RunTaskActionHandler(task, prod)
+				cmd.Spec = "[ARGS...]"
+				args := cmd.StringsArg("ARGS", []string{}, "Extra arguments given to the command of the task (use '--' before arguments starting with '-')")
+
+				cmd.Action = actions.RunTaskActionHandler(task, &executionContext, args)
 			})
 		}
 	})
 
-	app.Command("backup", "Perform a backup of the project", func(cmd *cli.Cmd) {
+	app.Command("backup", "Perform a backup of the project (or inspect / verify a backup)", func(cmd *cli.Cmd) {
+
+		cmd.Command("inspect", "List the content of a backup", func(cmd *cli.Cmd) {
+
+			// a backup can be inspected outside of its project
+			checkConfig = false
+
+			cmd.Spec = "[-k | --identity] FILE"
+
+			key := cmd.StringOpt("k", "", "the encryption password")
+			identity := cmd.StringOpt("identity", "", "the file of the private key (backups encrypted with public keys)")
+			file := cmd.StringArg("FILE", "", "A pliz backup file (tar.gz)")
+
+			cmd.Action = func() {
+				err := actions.InspectBackupActionHandler(*file, key, identity)
+				if err != nil {
+					fmt.Printf("\n%s: %v\n", color.RedString("Error"), err)
+					cli.Exit(1)
+				}
+			}
+		})
+
+		cmd.Command("verify", "Check that a backup is complete and not corrupted", func(cmd *cli.Cmd) {
+
+			// a backup can be verified outside of its project
+			checkConfig = false
+
+			cmd.Spec = "[-k | --identity] [--test-restore] [-v] FILE"
+
+			key := cmd.StringOpt("k", "", "the encryption password")
+			identity := cmd.StringOpt("identity", "", "the file of the private key (backups encrypted with public keys)")
+			testRestore := cmd.BoolOpt("test-restore", false, "Import the database dumps into throwaway containers of their images")
+			verbose := cmd.BoolOpt("v", false, "Display every file checked")
+			file := cmd.StringArg("FILE", "", "A pliz backup file (tar.gz)")
+
+			cmd.Action = func() {
+				err := actions.VerifyBackupActionHandler(*file, key, identity, *testRestore, *verbose)
+				if err != nil {
+					fmt.Printf("\n%s: %v\n", color.RedString("Error"), err)
+					cli.Exit(domain.ExitCode(err))
+				}
+			}
+		})
+
+		cmd.Command("keygen", "Generate a key pair to encrypt the backups with public keys", func(cmd *cli.Cmd) {
+
+			// the keys are not related to a project
+			checkConfig = false
+
+			cmd.Spec = "[-o]"
+
+			output := cmd.StringOpt("o output", "", "The file of the private key (printed if not set)")
+
+			cmd.Action = func() {
+				err := actions.BackupKeygenActionHandler(*output)
+				if err != nil {
+					fmt.Printf("\n%s: %v\n", color.RedString("Error"), err)
+					cli.Exit(1)
+				}
+			}
+		})
 
 		cmd.Spec = "[-q [--files] [--db]] [-o] [-k] [-v]"
 
@@ -283,7 +425,7 @@ func main() {
 		backupDB := cmd.BoolOpt("db", false, "Indicates if DB will be backup")
 
 		outputFilename := cmd.StringOpt("o output", "", "Set the filename of the tar.gz")
-		key := cmd.StringOpt("k", "", "the encryption password")
+		key := cmd.StringOpt("k", "", "the encryption password (the recipients of the config are used if not set)")
 		verbose := cmd.BoolOpt("v", false, "Display more informations during the restore process")
 
 		cmd.Action = func() {
@@ -295,20 +437,21 @@ func main() {
 			err := actions.BackupActionHandler(executionContext, backupFiles, backupDB, outputFilename, key, *verbose)
 			if err != nil {
 				fmt.Printf("\n%s: %v\n", color.RedString("Error during backup"), err)
-				cli.Exit(1)
+				cli.Exit(domain.ExitCode(err))
 			}
 		}
 	})
 
 	app.Command("restore", "Restore a backup (Warning: files will be overrided)", func(cmd *cli.Cmd) {
 
-		cmd.Spec = "[-q [--config-files] [--files] [--db]] [-k] [-v] FILE"
+		cmd.Spec = "[-q [--config-files] [--files] [--db]] [-k | --identity] [-v] FILE"
 
 		quiet := cmd.BoolOpt("q quiet", false, "Avoid prompt")
 		restoreConfigFiles := cmd.BoolOpt("config-files", false, "Indicates if config files will be restored")
 		restoreFiles := cmd.BoolOpt("files", false, "Indicates if files will be restored")
 		restoreDB := cmd.BoolOpt("db", false, "Indicates if DB will be restored")
 		key := cmd.StringOpt("k", "", "the encryption password")
+		identity := cmd.StringOpt("identity", "", "the file of the private key (backups encrypted with public keys)")
 		verbose := cmd.BoolOpt("v", false, "Display more informations during the restore process")
 
 		file := cmd.StringArg("FILE", "", "A pliz backup file (tar.gz)")
@@ -320,15 +463,151 @@ func main() {
 				restoreDB = nil
 			}
 
-			actions.RestoreActionHandler(executionContext, *file, restoreConfigFiles, restoreFiles, restoreDB, key, *verbose)
+			err := actions.RestoreActionHandler(executionContext, *file, restoreConfigFiles, restoreFiles, restoreDB, key, identity, *verbose)
+			if err != nil {
+				fmt.Printf("\n%s: %v\n", color.RedString("Error during restore"), err)
+				cli.Exit(domain.ExitCode(err))
+			}
 		}
 	})
 
+	app.Command("db", "Manage the database of the db service (or the specified service)", func(cmd *cli.Cmd) {
+
+		// parse the config (checked before the execution of the command)
+		loadConfig()
+
+		service := cmd.StringOpt("s service", config.Get().Containers.Db, "The Compose service of the database")
+
+		cmd.Command("shell", "Open an interactive client (mysql, psql or mongosh)", func(cmd *cli.Cmd) {
+			cmd.Action = func() {
+				err := actions.DbShellActionHandler(executionContext, *service)
+				if err != nil {
+					fmt.Printf("\n%s: %v\n", color.RedString("Unable to open the database client"), err)
+					cli.Exit(domain.ExitCode(err))
+				}
+			}
+		})
+
+		cmd.Command("dump", "Dump a database (the default one of the service if not set) into a file or stdout", func(cmd *cli.Cmd) {
+
+			cmd.Spec = "[-o] [-v] [DATABASE]"
+
+			output := cmd.StringOpt("o output", "", "The file of the dump (stdout if not set)")
+			verbose := cmd.BoolOpt("v", false, "Display more informations during the dump")
+			database := cmd.StringArg("DATABASE", "", "The database to dump")
+
+			cmd.Action = func() {
+				err := actions.DbDumpActionHandler(executionContext, *service, *database, *output, *verbose)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "\n%s: %v\n", color.RedString("Unable to dump the database"), err)
+					cli.Exit(domain.ExitCode(err))
+				}
+			}
+		})
+
+		cmd.Command("import", "Import a dump (.sql, .dump or .archive) into a database (the default one of the service if not set)", func(cmd *cli.Cmd) {
+
+			cmd.Spec = "[-q] [-v] FILE [DATABASE]"
+
+			quiet := cmd.BoolOpt("q quiet", false, "Avoid prompt")
+			verbose := cmd.BoolOpt("v", false, "Display more informations during the import")
+			file := cmd.StringArg("FILE", "", "The dump to import ('-' to read it from stdin)")
+			database := cmd.StringArg("DATABASE", "", "The database in which the dump is imported")
+
+			cmd.Action = func() {
+				if executionContext.IsProtected() && !*quiet {
+					ok := prompter.YN(fmt.Sprintf("You're in the protected environment '%s'. Are you sure you want to continue?", executionContext.Env), false)
+					if !ok {
+						return
+					}
+				}
+
+				err := actions.DbImportActionHandler(executionContext, *service, *file, *database, *verbose)
+				if err != nil {
+					fmt.Printf("\n%s: %v\n", color.RedString("Unable to import the dump"), err)
+					cli.Exit(domain.ExitCode(err))
+				}
+			}
+		})
+	})
+
+	app.Command("config", "Display or validate the configuration", func(cmd *cli.Cmd) {
+
+		cmd.Command("show", "Display the resolved configuration (defaults and overrides applied)", func(cmd *cli.Cmd) {
+
+			format := cmd.StringOpt("f format", "yaml", "Output format: yaml or json")
+
+			cmd.Action = func() {
+				err := actions.ConfigShowActionHandler(*format)
+				if err != nil {
+					fmt.Printf("\n%s: %v\n", color.RedString("Error"), err)
+					cli.Exit(1)
+				}
+			}
+		})
+
+		cmd.Command("validate", "Check the configuration and display all the errors", func(cmd *cli.Cmd) {
+
+			// the errors are reported by the command
+			checkConfig = false
+
+			cmd.Action = func() {
+				err := actions.ConfigValidateActionHandler(executionContext)
+				if err != nil {
+					fmt.Printf("\n%s: %v\n", color.RedString("Error"), err)
+					cli.Exit(1)
+				}
+			}
+		})
+
+		cmd.Command("migrate", "Update pliz.yml and its overlays to the current version of the config (a backup of each file is kept in <file>.bak)", func(cmd *cli.Cmd) {
+
+			// an old config can't be loaded
+			checkConfig = false
+
+			cmd.Action = func() {
+				err := actions.ConfigMigrateActionHandler()
+				if err != nil {
+					fmt.Printf("\n%s: %v\n", color.RedString("Error"), err)
+					cli.Exit(1)
+				}
+			}
+		})
+	})
+
 	app.Run(os.Args)
 }
 
-func parseAndCheckConfig() {
-	err := config.Check()
+// loadConfig parses the config while the commands are declared (i.e. to list the tasks),
+// so the env is read from the args before their parsing
+func loadConfig() {
+	err := config.Load(envFromArgs(os.Args[1:]))
+	if err != nil {
+		os.Exit(1)
+		return
+	}
+}
+
+// envFromArgs returns the value of the '--env' option of the app (or the 'PLIZ_ENV' environment var)
+func envFromArgs(args []string) string {
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "--env=") {
+			return strings.TrimPrefix(arg, "--env=")
+		}
+		if arg == "--env" && i+1 < len(args) {
+			return args[i+1]
+		}
+		// the options of the app are before the command
+		if !strings.HasPrefix(arg, "-") && (i == 0 || args[i-1] != "--env") {
+			break
+		}
+	}
+
+	return os.Getenv("PLIZ_ENV")
+}
+
+func parseAndCheckConfig(ctx domain.ExecutionContext) {
+	err := config.Check(ctx)
 	if err != nil {
 		os.Exit(1)
 		return
@@ -10,11 +10,11 @@ func CreateTaskWithName(name domain.TaskID, config domain.Config) (domain.Task,
 	// default tasks
 	switch name {
 	case "npm":
-		return NpmTask(config.Containers.Builder), nil
+		return NpmTask(config.Containers.Builder, config.DefaultTasksCheck), nil
 	case "bower":
-		return BowerTask(config.Containers.Builder), nil
+		return BowerTask(config.Containers.Builder, config.DefaultTasksCheck), nil
 	case "composer":
-		return ComposerTask(config.Containers.App), nil
+		return ComposerTask(config.Containers.App, config.DefaultTasksCheck), nil
 	case "gulp":
 		return GulpTask(config.Containers.Builder), nil
 	case "db:update":
@@ -3,11 +3,15 @@ package tasks
 import "webup/pliz/domain"
 
 // Create a task for running 'npm install'
-func NpmTask(container string) domain.Task {
+func NpmTask(container string, checkMethod domain.ExecutionCheckMethod) domain.Task {
 	task := domain.Task{Name: "npm", Description: "Run 'npm install' in the build container"}
 
-	// check if 'package.json' has been updated since last install into 'node_modules'
-	task.ExecutionCheck = &domain.ModificationDateTaskExecutionCheck{UpdatedFile: "package.json", CompareTo: "node_modules"}
+	// check if 'package.json' (or the lock files) has been updated since last install into 'node_modules'
+	if checkMethod == domain.ContentHashCheckMethod {
+		task.ExecutionCheck = &domain.ContentHashTaskExecutionCheck{Key: "npm", Files: []string{"package.json", "package-lock.json", "yarn.lock"}, Target: "node_modules"}
+	} else {
+		task.ExecutionCheck = &domain.ModificationDateTaskExecutionCheck{UpdatedFile: "package.json", CompareTo: "node_modules"}
+	}
 
 	// execute 'npm install' into the builder container
 	task.Container = &container
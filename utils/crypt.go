@@ -14,6 +14,7 @@ import (
 	"crypto/sha512"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 
 	"golang.org/x/crypto/scrypt"
@@ -23,87 +24,24 @@ import (
 const BUFFER_SIZE int = 16 * 1024
 const IV_SIZE int = 16
 const SALT_SIZE int = 32
-const V1 byte = 0x1
 const hmacSize = sha512.Size
 
+// Versions of the encryption formats (first byte of the stream)
+const V1 byte = 0x1 // AES-CTR with a trailing HMAC, only decrypted
+const V2 byte = 0x2 // AES-256-GCM chunks, key derived from a password (see crypt_v2.go)
+const V3 byte = 0x3 // AES-256-GCM chunks, key encrypted for X25519 public keys (see crypt_x25519.go)
+
 // ErrInvalidHMAC for authentication failure
 var ErrInvalidHMAC = errors.New("Invalid HMAC")
 
-// Encrypt the stream using the given AES-CTR and SHA512-HMAC key
+// Encrypt the stream with the V2 format (see crypt_v2.go), the V1 format is only decrypted
 func Encrypt(in io.Reader, out io.Writer, key []byte) (err error) {
-	keyAes, saltAes, err := DeriveKey(key, nil)
-	if err != nil {
-		return err
-	}
-	keyHmac, saltHmac, err := DeriveKey(key, nil)
-	if err != nil {
-		return err
-	}
-
-	iv := make([]byte, IV_SIZE)
-	_, err = rand.Read(iv)
-	if err != nil {
-		return err
-	}
-
-	AES, err := aes.NewCipher(keyAes)
-	if err != nil {
-		return err
-	}
-
-	ctr := cipher.NewCTR(AES, iv)
-	HMAC := hmac.New(sha512.New, keyHmac) // https://golang.org/pkg/crypto/hmac/#New
-
-	// Version
-	_, err = out.Write([]byte{V1})
-	if err != nil {
-		return err
-	}
-
-	w := io.MultiWriter(out, HMAC)
-
-	_, err = w.Write(iv)
-	if err != nil {
-		return err
-	}
-	_, err = w.Write(saltAes)
-	if err != nil {
-		return err
-	}
-	_, err = w.Write(saltHmac)
-	if err != nil {
-		return err
-	}
-
-	buf := make([]byte, BUFFER_SIZE)
-	for {
-		n, err := in.Read(buf)
-		if err != nil && err != io.EOF {
-			return err
-		}
-
-		if n != 0 {
-			outBuf := make([]byte, n)
-			ctr.XORKeyStream(outBuf, buf[:n])
-			_, err = w.Write(outBuf)
-			if err != nil {
-				return err
-			}
-		}
-
-		if err == io.EOF {
-			break
-		}
-	}
-
-	_, err = out.Write(HMAC.Sum(nil))
-
-	return err
+	return encryptV2(in, out, key)
 }
 
-// Decrypt the stream and verify HMAC using the given AES-CTR and SHA512-HMAC key
-// Do not trust the out io.Writer contents until the function returns the result
-// of validating the ending HMAC hash.
+// Decrypt the stream, with the format given by its version byte.
+// With V1, do not trust the out io.Writer contents until the function returns (the HMAC is at the end).
+// With V2, each chunk written to out has been authenticated, but the stream may be truncated until the function returns.
 func Decrypt(in io.Reader, out io.Writer, key []byte) (err error) {
 
 	// Read version (up to 0-255)
@@ -113,6 +51,21 @@ func Decrypt(in io.Reader, out io.Writer, key []byte) (err error) {
 		return err
 	}
 
+	switch byte(version) {
+	case V1:
+		return decryptV1(in, out, key)
+	case V2:
+		return decryptV2(in, out, key)
+	case V3:
+		return errors.New("The stream is encrypted with public keys: it can only be decrypted with a private key")
+	}
+	return fmt.Errorf("Unsupported encryption format (version %d)", version)
+}
+
+// decryptV1 decrypts the stream and verify HMAC using the given AES-CTR and SHA512-HMAC key
+// Do not trust the out io.Writer contents until the function returns the result
+// of validating the ending HMAC hash.
+func decryptV1(in io.Reader, out io.Writer, key []byte) (err error) {
 	iv := make([]byte, IV_SIZE)
 	_, err = io.ReadFull(in, iv)
 	if err != nil {
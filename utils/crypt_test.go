@@ -0,0 +1,247 @@
+package utils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testPassword = []byte("correct horse battery staple")
+
+// testContent returns a content of the length (not a repeated chunk: the chunks can't be swapped without being detected)
+func testContent(length int) []byte {
+	content := make([]byte, length)
+	for i := range content {
+		content[i] = byte(i * 7 / 3)
+	}
+	return content
+}
+
+func encryptTest(t *testing.T, content []byte) []byte {
+	t.Helper()
+
+	var encrypted bytes.Buffer
+	if err := Encrypt(bytes.NewReader(content), &encrypted, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	return encrypted.Bytes()
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int
+	}{
+		{"empty", 0},
+		{"one byte", 1},
+		{"smaller than a chunk", v2ChunkSize - 1},
+		{"one chunk", v2ChunkSize},
+		{"one chunk and one byte", v2ChunkSize + 1},
+		{"several chunks", 3*v2ChunkSize + 100},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := testContent(test.length)
+			encrypted := encryptTest(t, content)
+
+			if encrypted[0] != V2 {
+				t.Errorf("expected the version %d, got %d", V2, encrypted[0])
+			}
+
+			var decrypted bytes.Buffer
+			if err := Decrypt(bytes.NewReader(encrypted), &decrypted, testPassword); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(decrypted.Bytes(), content) {
+				t.Errorf("the decrypted content (%d bytes) differs from the content (%d bytes)", decrypted.Len(), len(content))
+			}
+		})
+	}
+}
+
+func TestDecryptInvalid(t *testing.T) {
+	// the last chunk is empty
+	encrypted := encryptTest(t, testContent(2*v2ChunkSize))
+	chunk := v2ChunkSize + 16
+
+	modified := func(modify func(data []byte) []byte) []byte {
+		return modify(append([]byte{}, encrypted...))
+	}
+
+	tests := []struct {
+		name      string
+		encrypted []byte
+		password  []byte
+		err       error
+		message   string
+	}{
+		{
+			name:      "wrong password",
+			encrypted: encrypted,
+			password:  []byte("wrong"),
+			err:       ErrInvalidChunk,
+		},
+		{
+			name: "modified salt",
+			encrypted: modified(func(data []byte) []byte {
+				data[10] ^= 1
+				return data
+			}),
+			err: ErrInvalidChunk,
+		},
+		{
+			name: "modified chunk",
+			encrypted: modified(func(data []byte) []byte {
+				data[v2HeaderSize+chunk+100] ^= 1
+				return data
+			}),
+			err: ErrInvalidChunk,
+		},
+		{
+			name: "swapped chunks",
+			encrypted: modified(func(data []byte) []byte {
+				first := append([]byte{}, data[v2HeaderSize:v2HeaderSize+chunk]...)
+				copy(data[v2HeaderSize:], data[v2HeaderSize+chunk:v2HeaderSize+2*chunk])
+				copy(data[v2HeaderSize+chunk:], first)
+				return data
+			}),
+			err: ErrInvalidChunk,
+		},
+		{
+			name: "last chunk removed",
+			encrypted: modified(func(data []byte) []byte {
+				return data[:len(data)-16]
+			}),
+			err: ErrTruncated,
+		},
+		{
+			name: "last chunks removed",
+			encrypted: modified(func(data []byte) []byte {
+				return data[:v2HeaderSize+chunk]
+			}),
+			err: ErrTruncated,
+		},
+		{
+			name: "truncated chunk",
+			encrypted: modified(func(data []byte) []byte {
+				return data[:v2HeaderSize+chunk+100]
+			}),
+			err: ErrInvalidChunk,
+		},
+		{
+			name: "truncated header",
+			encrypted: modified(func(data []byte) []byte {
+				return data[:v2HeaderSize-1]
+			}),
+			message: "unexpected EOF",
+		},
+		{
+			name: "scrypt parameters above the limits",
+			encrypted: modified(func(data []byte) []byte {
+				data[2] = scryptMaxLogN + 1
+				return data
+			}),
+			message: "Invalid scrypt parameters (N=2^16, r=8, p=1)",
+		},
+		{
+			name: "scrypt parameters below the limits",
+			encrypted: modified(func(data []byte) []byte {
+				data[3] = 0
+				return data
+			}),
+			message: "Invalid scrypt parameters (N=2^15, r=0, p=1)",
+		},
+		{
+			name: "invalid chunk size",
+			encrypted: modified(func(data []byte) []byte {
+				copy(data[v2HeaderSize-4:], []byte{0xff, 0, 0, 0})
+				return data
+			}),
+			message: "Invalid chunk size",
+		},
+		{
+			name:      "encrypted with public keys",
+			encrypted: []byte{V3, 1},
+			message:   "encrypted with public keys",
+		},
+		{
+			name:      "unknown version",
+			encrypted: []byte{0x42},
+			message:   "Unsupported encryption format (version 66)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			password := test.password
+			if password == nil {
+				password = testPassword
+			}
+
+			err := Decrypt(bytes.NewReader(test.encrypted), &bytes.Buffer{}, password)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if test.err != nil && err != test.err {
+				t.Errorf("expected the error '%v', got '%v'", test.err, err)
+			}
+			if test.message != "" && !strings.Contains(err.Error(), test.message) {
+				t.Errorf("expected an error containing '%s', got '%v'", test.message, err)
+			}
+		})
+	}
+}
+
+// testdata/v1.enc is testdata/v1.txt encrypted by the V1 Encrypt (removed) with the password 'v1 password'
+func TestDecryptV1(t *testing.T) {
+	encrypted, err := ioutil.ReadFile(filepath.Join("testdata", "v1.enc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "v1.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decrypted bytes.Buffer
+	if err := Decrypt(bytes.NewReader(encrypted), &decrypted, []byte("v1 password")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), content) {
+		t.Errorf("the decrypted content (%d bytes) differs from the content (%d bytes)", decrypted.Len(), len(content))
+	}
+
+	modified := func(index int) []byte {
+		data := append([]byte{}, encrypted...)
+		data[index] ^= 1
+		return data
+	}
+
+	tests := []struct {
+		name      string
+		encrypted []byte
+		password  string
+	}{
+		{name: "wrong password", encrypted: encrypted, password: "wrong"},
+		{name: "modified HMAC", encrypted: modified(len(encrypted) - 1)},
+		{name: "modified content", encrypted: modified(1 + IV_SIZE + 2*SALT_SIZE + 100)},
+		{name: "modified IV", encrypted: modified(1)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			password := test.password
+			if password == "" {
+				password = "v1 password"
+			}
+
+			err := Decrypt(bytes.NewReader(test.encrypted), &bytes.Buffer{}, []byte(password))
+			if err != ErrInvalidHMAC {
+				t.Errorf("expected the error '%v', got '%v'", ErrInvalidHMAC, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// V2 format: a header, then the stream split into chunks encrypted with AES-256-GCM.
+// Each chunk is authenticated on its own: its content can be used as soon as it's decrypted.
+//
+//	version (1) | kdf (1) | log2(N) (1) | r (1) | p (1) | salt (32) | chunk size (4, big endian)
+//	chunk 0 | chunk 1 | ... | last chunk (smaller than the chunk size, maybe empty)
+//
+// The nonce of a chunk is its index (11 bytes, big endian) followed by 1 for the last chunk, 0 otherwise:
+// the chunks can't be reordered, and a truncated stream is detected. The header is the additional data of every chunk.
+//
+// The scrypt parameters read from a header are capped to the ones written by Encrypt (N = 2^15, r = 8, p = 1):
+// a crafted header can't make the key derivation use more memory (32 MB) or time. The caps must be raised
+// with the parameters written by Encrypt.
+const (
+	kdfScrypt         byte = 0x1
+	v2HeaderSize           = 1 + 4 + 32 + 4
+	v2ChunkSize            = 64 * 1024
+	v2MaxChunkSize         = 16 * 1024 * 1024
+	scryptDefaultLogN      = 15 // N = 32768, as in V1
+	scryptDefaultR         = 8
+	scryptDefaultP         = 1
+	scryptMinLogN          = 10
+	scryptMaxLogN          = scryptDefaultLogN
+	scryptMaxR             = scryptDefaultR
+	scryptMaxP             = scryptDefaultP
+)
+
+// ErrInvalidChunk for authentication failure of a chunk (wrong key or corrupted data)
+var ErrInvalidChunk = errors.New("Invalid authentication of an encrypted chunk")
+
+// ErrTruncated when the last chunk is missing
+var ErrTruncated = errors.New("The encrypted stream is truncated")
+
+// scryptParams are the parameters of the key derivation, written in the header
+type scryptParams struct {
+	LogN byte
+	R    byte
+	P    byte
+}
+
+func (p scryptParams) validate() error {
+	if p.LogN < scryptMinLogN || p.LogN > scryptMaxLogN || p.R < 1 || p.R > scryptMaxR || p.P < 1 || p.P > scryptMaxP {
+		return fmt.Errorf("Invalid scrypt parameters (N=2^%d, r=%d, p=%d)", p.LogN, p.R, p.P)
+	}
+	return nil
+}
+
+func (p scryptParams) deriveKey(password, salt []byte) ([]byte, error) {
+	return scrypt.Key(password, salt, 1<<p.LogN, int(p.R), int(p.P), 32)
+}
+
+// encryptV2 encrypts the stream into chunks of AES-256-GCM with a key derived from the password
+func encryptV2(in io.Reader, out io.Writer, password []byte) error {
+	params := scryptParams{LogN: scryptDefaultLogN, R: scryptDefaultR, P: scryptDefaultP}
+
+	salt := make([]byte, SALT_SIZE)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	key, err := params.deriveKey(password, salt)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 0, v2HeaderSize)
+	header = append(header, V2, kdfScrypt, params.LogN, params.R, params.P)
+	header = append(header, salt...)
+	header = append(header, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(header[v2HeaderSize-4:], v2ChunkSize)
+
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+
+	return encryptChunks(in, out, key, header, v2ChunkSize)
+}
+
+// decryptV2 decrypts the stream (its version has already been read). Each chunk is written once authenticated.
+func decryptV2(in io.Reader, out io.Writer, password []byte) error {
+	header := make([]byte, v2HeaderSize)
+	header[0] = V2
+	if _, err := io.ReadFull(in, header[1:]); err != nil {
+		return err
+	}
+
+	if header[1] != kdfScrypt {
+		return fmt.Errorf("Unsupported key derivation function (%d)", header[1])
+	}
+	params := scryptParams{LogN: header[2], R: header[3], P: header[4]}
+	if err := params.validate(); err != nil {
+		return err
+	}
+	salt := header[5 : 5+SALT_SIZE]
+	chunkSize := binary.BigEndian.Uint32(header[v2HeaderSize-4:])
+	if chunkSize == 0 || chunkSize > v2MaxChunkSize {
+		return fmt.Errorf("Invalid chunk size (%d)", chunkSize)
+	}
+
+	key, err := params.deriveKey(password, salt)
+	if err != nil {
+		return err
+	}
+
+	return decryptChunks(in, out, key, header, int(chunkSize))
+}
+
+func encryptChunks(in io.Reader, out io.Writer, key []byte, header []byte, chunkSize int) error {
+	aead, err := newChunkCipher(key)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, chunkSize)
+	sealed := make([]byte, 0, chunkSize+aead.Overhead())
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(in, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		// the last chunk is the first one which is not full
+		last := n < chunkSize
+
+		sealed = aead.Seal(sealed[:0], chunkNonce(index, last), buf[:n], header)
+		if _, err := out.Write(sealed); err != nil {
+			return err
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+func decryptChunks(in io.Reader, out io.Writer, key []byte, header []byte, chunkSize int) error {
+	aead, err := newChunkCipher(key)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, chunkSize+aead.Overhead())
+	opened := make([]byte, 0, chunkSize)
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(in, buf)
+		if err == io.EOF {
+			return ErrTruncated
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := n < len(buf)
+
+		opened, err = aead.Open(opened[:0], chunkNonce(index, last), buf[:n], header)
+		if err != nil {
+			return ErrInvalidChunk
+		}
+		if _, err := out.Write(opened); err != nil {
+			return err
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+func newChunkCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(index uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
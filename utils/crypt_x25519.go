@@ -0,0 +1,276 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// V3 format: the stream is encrypted as in V2 with a random file key, which is encrypted for each recipient (X25519).
+//
+//	version (1) | recipients count (1) | recipients | chunk size (4, big endian)
+//	chunk 0 | chunk 1 | ... | last chunk
+//
+// A recipient is an ephemeral public key (32) and the file key encrypted (32 + 16) with AES-256-GCM by the key
+// derived (HKDF-SHA256) from the X25519 shared secret of the ephemeral key and the public key of the recipient.
+
+const (
+	PublicKeyPrefix  = "pliz-pub-"
+	PrivateKeyPrefix = "PLIZ-SECRET-KEY-"
+	v3RecipientSize  = 32 + 32 + 16
+)
+
+// ErrNoIdentity when none of the identities is a recipient of the stream
+var ErrNoIdentity = errors.New("No identity matches the recipients of the encrypted stream")
+
+// PublicKey is the X25519 public key of a recipient
+type PublicKey [32]byte
+
+// PrivateKey is the X25519 private key of an identity
+type PrivateKey [32]byte
+
+// GenerateKey returns a new identity
+func GenerateKey() (PrivateKey, error) {
+	var key PrivateKey
+	if _, err := rand.Read(key[:]); err != nil {
+		return key, err
+	}
+	return key, nil
+}
+
+func (k PrivateKey) Public() PublicKey {
+	var public PublicKey
+	// the product of a (clamped) scalar and the base point is never a low order point: no error
+	point, _ := x25519(k[:], x25519Basepoint)
+	copy(public[:], point)
+	return public
+}
+
+func (k PrivateKey) String() string {
+	return PrivateKeyPrefix + base64.RawURLEncoding.EncodeToString(k[:])
+}
+
+func (k PublicKey) String() string {
+	return PublicKeyPrefix + base64.RawURLEncoding.EncodeToString(k[:])
+}
+
+// ParsePublicKey reads a public key ('pliz-pub-...')
+func ParsePublicKey(value string) (PublicKey, error) {
+	var key PublicKey
+	err := parseKey(value, PublicKeyPrefix, key[:])
+	return key, err
+}
+
+// ParsePrivateKey reads a private key ('PLIZ-SECRET-KEY-...')
+func ParsePrivateKey(value string) (PrivateKey, error) {
+	var key PrivateKey
+	err := parseKey(value, PrivateKeyPrefix, key[:])
+	return key, err
+}
+
+func parseKey(value string, prefix string, key []byte) error {
+	if !strings.HasPrefix(value, prefix) {
+		return fmt.Errorf("Invalid key (it must start with '%s')", prefix)
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, prefix))
+	if err != nil || len(decoded) != len(key) {
+		return fmt.Errorf("Invalid key (not a base64 encoded key of %d bytes)", len(key))
+	}
+	copy(key, decoded)
+	return nil
+}
+
+// ReadIdentityFile reads the private keys of the file (one per line, '#' for the comments)
+func ReadIdentityFile(file string) ([]PrivateKey, error) {
+	reader, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	keys := []PrivateKey{}
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		value := strings.TrimSpace(scanner.Text())
+		if value == "" || strings.HasPrefix(value, "#") {
+			continue
+		}
+		key, err := ParsePrivateKey(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("No private key in %s", file)
+	}
+	return keys, nil
+}
+
+// EncryptToRecipients encrypts the stream with the V3 format: it can be decrypted by any of the recipients
+func EncryptToRecipients(in io.Reader, out io.Writer, recipients []PublicKey) error {
+	if len(recipients) == 0 || len(recipients) > 255 {
+		return fmt.Errorf("Invalid number of recipients (%d)", len(recipients))
+	}
+
+	fileKey := make([]byte, 32)
+	if _, err := rand.Read(fileKey); err != nil {
+		return err
+	}
+
+	header := []byte{V3, byte(len(recipients))}
+	for _, recipient := range recipients {
+		ephemeral, err := GenerateKey()
+		if err != nil {
+			return err
+		}
+		ephemeralPublic := ephemeral.Public()
+
+		wrapKey, err := recipientWrapKey(ephemeral, recipient, ephemeralPublic, recipient)
+		if err != nil {
+			return err
+		}
+		aead, err := newChunkCipher(wrapKey)
+		if err != nil {
+			return err
+		}
+
+		// the wrap key is used once: the nonce can be constant
+		header = append(header, ephemeralPublic[:]...)
+		header = aead.Seal(header, make([]byte, aead.NonceSize()), fileKey, nil)
+	}
+	chunkSize := make([]byte, 4)
+	binary.BigEndian.PutUint32(chunkSize, v2ChunkSize)
+	header = append(header, chunkSize...)
+
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+
+	key, err := payloadKey(fileKey)
+	if err != nil {
+		return err
+	}
+
+	return encryptChunks(in, out, key, header, v2ChunkSize)
+}
+
+// DecryptWithIdentities decrypts a V3 stream with the private key of one of its recipients.
+// Each chunk written to out has been authenticated, but the stream may be truncated until the function returns.
+func DecryptWithIdentities(in io.Reader, out io.Writer, identities []PrivateKey) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(in, header); err != nil {
+		return err
+	}
+	if header[0] != V3 {
+		return fmt.Errorf("The stream is not encrypted with public keys (version %d)", header[0])
+	}
+
+	recipients := make([]byte, int(header[1])*v3RecipientSize+4)
+	if _, err := io.ReadFull(in, recipients); err != nil {
+		return err
+	}
+	header = append(header, recipients...)
+
+	var fileKey []byte
+	for i := 0; i < int(header[1]) && fileKey == nil; i++ {
+		recipient := recipients[i*v3RecipientSize : (i+1)*v3RecipientSize]
+		var ephemeralPublic PublicKey
+		copy(ephemeralPublic[:], recipient[:32])
+
+		for _, identity := range identities {
+			wrapKey, err := recipientWrapKey(identity, ephemeralPublic, ephemeralPublic, identity.Public())
+			if err != nil {
+				continue
+			}
+			aead, err := newChunkCipher(wrapKey)
+			if err != nil {
+				return err
+			}
+			if key, err := aead.Open(nil, make([]byte, aead.NonceSize()), recipient[32:], nil); err == nil {
+				fileKey = key
+				break
+			}
+		}
+	}
+	if fileKey == nil {
+		return ErrNoIdentity
+	}
+
+	chunkSize := binary.BigEndian.Uint32(header[len(header)-4:])
+	if chunkSize == 0 || chunkSize > v2MaxChunkSize {
+		return fmt.Errorf("Invalid chunk size (%d)", chunkSize)
+	}
+
+	key, err := payloadKey(fileKey)
+	if err != nil {
+		return err
+	}
+
+	return decryptChunks(in, out, key, header, int(chunkSize))
+}
+
+// recipientWrapKey derives the key encrypting the file key for a recipient from the X25519 shared secret
+// (private key of the ephemeral key with the public key of the recipient, or the opposite)
+func recipientWrapKey(private PrivateKey, peer PublicKey, ephemeral PublicKey, recipient PublicKey) ([]byte, error) {
+	shared, err := x25519(private[:], peer[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// the key is bound to the ephemeral key and the recipient
+	salt := append(append([]byte{}, ephemeral[:]...), recipient[:]...)
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("pliz X25519")), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// payloadKey derives the key of the chunks from the file key
+func payloadKey(fileKey []byte) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, fileKey, nil, []byte("pliz payload")), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// x25519Basepoint is the generator of the curve (public key = x25519(private key, x25519Basepoint))
+var x25519Basepoint = []byte{9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+
+// x25519 is the X25519 function (RFC 7748) of the scalar and the point, as curve25519.X25519 of the recent
+// versions of golang.org/x/crypto: a low order point (all-zero output) is refused, in constant time.
+// The version of golang.org/x/crypto used by pliz only provides the deprecated ScalarMult: this function
+// must be replaced by curve25519.X25519 when the dependency is updated.
+func x25519(scalar, point []byte) ([]byte, error) {
+	if len(scalar) != 32 || len(point) != 32 {
+		return nil, fmt.Errorf("Invalid X25519 key length (%d, %d)", len(scalar), len(point))
+	}
+
+	var dst, in, base [32]byte
+	copy(in[:], scalar)
+	copy(base[:], point)
+	curve25519.ScalarMult(&dst, &in, &base)
+
+	if subtle.ConstantTimeCompare(dst[:], make([]byte, 32)) == 1 {
+		return nil, errors.New("Invalid public key (low order point)")
+	}
+	return dst[:], nil
+}
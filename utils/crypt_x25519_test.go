@@ -0,0 +1,316 @@
+package utils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func generateTestKey(t *testing.T) PrivateKey {
+	t.Helper()
+
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func encryptToRecipientsTest(t *testing.T, content []byte, recipients ...PrivateKey) []byte {
+	t.Helper()
+
+	publicKeys := []PublicKey{}
+	for _, recipient := range recipients {
+		publicKeys = append(publicKeys, recipient.Public())
+	}
+
+	var encrypted bytes.Buffer
+	if err := EncryptToRecipients(bytes.NewReader(content), &encrypted, publicKeys); err != nil {
+		t.Fatal(err)
+	}
+	return encrypted.Bytes()
+}
+
+func TestEncryptToRecipients(t *testing.T) {
+	alice, bob, carol := generateTestKey(t), generateTestKey(t), generateTestKey(t)
+
+	tests := []struct {
+		name       string
+		length     int
+		recipients []PrivateKey
+		identities []PrivateKey
+	}{
+		{name: "empty", length: 0, recipients: []PrivateKey{alice}, identities: []PrivateKey{alice}},
+		{name: "one chunk", length: v2ChunkSize, recipients: []PrivateKey{alice}, identities: []PrivateKey{alice}},
+		{name: "several chunks", length: 2*v2ChunkSize + 1, recipients: []PrivateKey{alice}, identities: []PrivateKey{alice}},
+		{name: "first recipient", length: 100, recipients: []PrivateKey{alice, bob}, identities: []PrivateKey{alice}},
+		{name: "second recipient", length: 100, recipients: []PrivateKey{alice, bob}, identities: []PrivateKey{bob}},
+		{name: "several identities", length: 100, recipients: []PrivateKey{alice, bob}, identities: []PrivateKey{carol, bob}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := testContent(test.length)
+			encrypted := encryptToRecipientsTest(t, content, test.recipients...)
+
+			if encrypted[0] != V3 || int(encrypted[1]) != len(test.recipients) {
+				t.Errorf("unexpected header: %v", encrypted[:2])
+			}
+
+			var decrypted bytes.Buffer
+			if err := DecryptWithIdentities(bytes.NewReader(encrypted), &decrypted, test.identities); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(decrypted.Bytes(), content) {
+				t.Errorf("the decrypted content (%d bytes) differs from the content (%d bytes)", decrypted.Len(), len(content))
+			}
+		})
+	}
+}
+
+func TestEncryptToRecipientsInvalid(t *testing.T) {
+	if err := EncryptToRecipients(bytes.NewReader(nil), &bytes.Buffer{}, []PublicKey{}); err == nil {
+		t.Error("expected an error without recipient")
+	}
+	if err := EncryptToRecipients(bytes.NewReader(nil), &bytes.Buffer{}, make([]PublicKey, 256)); err == nil {
+		t.Error("expected an error with more than 255 recipients")
+	}
+}
+
+func TestDecryptWithIdentitiesInvalid(t *testing.T) {
+	alice, bob, carol := generateTestKey(t), generateTestKey(t), generateTestKey(t)
+	// the last chunk is empty
+	encrypted := encryptToRecipientsTest(t, testContent(2*v2ChunkSize), alice, bob)
+	headerSize := 2 + 2*v3RecipientSize + 4
+	chunk := v2ChunkSize + 16
+
+	modified := func(modify func(data []byte) []byte) []byte {
+		return modify(append([]byte{}, encrypted...))
+	}
+
+	tests := []struct {
+		name       string
+		encrypted  []byte
+		identities []PrivateKey
+		err        error
+		message    string
+	}{
+		{
+			name:       "not a recipient",
+			encrypted:  encrypted,
+			identities: []PrivateKey{carol},
+			err:        ErrNoIdentity,
+		},
+		{
+			name: "modified ephemeral key",
+			encrypted: modified(func(data []byte) []byte {
+				data[2] ^= 1
+				data[2+v3RecipientSize] ^= 1
+				return data
+			}),
+			err: ErrNoIdentity,
+		},
+		{
+			name: "modified file key",
+			encrypted: modified(func(data []byte) []byte {
+				data[2+40] ^= 1
+				data[2+v3RecipientSize+40] ^= 1
+				return data
+			}),
+			err: ErrNoIdentity,
+		},
+		{
+			// the header is authenticated by the chunks: the other recipient can't be replaced
+			name: "modified recipient of another identity",
+			encrypted: modified(func(data []byte) []byte {
+				data[2+v3RecipientSize+40] ^= 1
+				return data
+			}),
+			err: ErrInvalidChunk,
+		},
+		{
+			name: "modified chunk",
+			encrypted: modified(func(data []byte) []byte {
+				data[headerSize+chunk+100] ^= 1
+				return data
+			}),
+			err: ErrInvalidChunk,
+		},
+		{
+			name: "last chunk removed",
+			encrypted: modified(func(data []byte) []byte {
+				return data[:len(data)-16]
+			}),
+			err: ErrTruncated,
+		},
+		{
+			name: "truncated chunk",
+			encrypted: modified(func(data []byte) []byte {
+				return data[:headerSize+chunk+100]
+			}),
+			err: ErrInvalidChunk,
+		},
+		{
+			name: "truncated header",
+			encrypted: modified(func(data []byte) []byte {
+				return data[:headerSize-1]
+			}),
+			message: "unexpected EOF",
+		},
+		{
+			name: "invalid chunk size",
+			encrypted: modified(func(data []byte) []byte {
+				copy(data[headerSize-4:], []byte{0xff, 0, 0, 0})
+				return data
+			}),
+			message: "Invalid chunk size",
+		},
+		{
+			name:      "encrypted with a password",
+			encrypted: []byte{V2, 1},
+			message:   "not encrypted with public keys (version 2)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identities := test.identities
+			if identities == nil {
+				identities = []PrivateKey{alice}
+			}
+
+			err := DecryptWithIdentities(bytes.NewReader(test.encrypted), &bytes.Buffer{}, identities)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if test.err != nil && err != test.err {
+				t.Errorf("expected the error '%v', got '%v'", test.err, err)
+			}
+			if test.message != "" && !strings.Contains(err.Error(), test.message) {
+				t.Errorf("expected an error containing '%s', got '%v'", test.message, err)
+			}
+		})
+	}
+}
+
+func TestX25519LowOrderPoint(t *testing.T) {
+	key := generateTestKey(t)
+	if _, err := x25519(key[:], make([]byte, 32)); err == nil {
+		t.Error("expected an error with a low order point")
+	}
+
+	// a recipient with a low order public key is skipped
+	encrypted := encryptToRecipientsTest(t, []byte("content"), key)
+	copy(encrypted[2:34], make([]byte, 32))
+	if err := DecryptWithIdentities(bytes.NewReader(encrypted), &bytes.Buffer{}, []PrivateKey{key}); err != ErrNoIdentity {
+		t.Errorf("expected the error '%v', got '%v'", ErrNoIdentity, err)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	private := generateTestKey(t)
+	public := private.Public()
+
+	parsedPrivate, err := ParsePrivateKey(private.String())
+	if err != nil || parsedPrivate != private {
+		t.Errorf("unable to parse the private key %s: %v", private, err)
+	}
+	parsedPublic, err := ParsePublicKey(public.String())
+	if err != nil || parsedPublic != public {
+		t.Errorf("unable to parse the public key %s: %v", public, err)
+	}
+
+	tests := []struct {
+		name    string
+		parse   func(value string) error
+		value   string
+		message string
+	}{
+		{
+			name:    "private key as a public key",
+			parse:   func(value string) error { _, err := ParsePublicKey(value); return err },
+			value:   private.String(),
+			message: "it must start with 'pliz-pub-'",
+		},
+		{
+			name:    "public key as a private key",
+			parse:   func(value string) error { _, err := ParsePrivateKey(value); return err },
+			value:   public.String(),
+			message: "it must start with 'PLIZ-SECRET-KEY-'",
+		},
+		{
+			name:    "invalid base64",
+			parse:   func(value string) error { _, err := ParsePublicKey(value); return err },
+			value:   PublicKeyPrefix + "not base64!",
+			message: "not a base64 encoded key of 32 bytes",
+		},
+		{
+			name:    "short key",
+			parse:   func(value string) error { _, err := ParsePublicKey(value); return err },
+			value:   public.String()[:len(public.String())-4],
+			message: "not a base64 encoded key of 32 bytes",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.parse(test.value)
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Errorf("expected an error containing '%s', got '%v'", test.message, err)
+			}
+		})
+	}
+}
+
+func TestReadIdentityFile(t *testing.T) {
+	alice, bob := generateTestKey(t), generateTestKey(t)
+
+	tests := []struct {
+		name    string
+		content string
+		keys    []PrivateKey
+		message string
+	}{
+		{
+			name:    "keys and comments",
+			content: "# alice\n" + alice.String() + "\n\n  " + bob.String() + "  \n",
+			keys:    []PrivateKey{alice, bob},
+		},
+		{
+			name:    "invalid key",
+			content: "# alice\n" + alice.String() + "\n" + bob.Public().String() + "\n",
+			message: "identity:3: Invalid key",
+		},
+		{
+			name:    "no key",
+			content: "# no key\n",
+			message: "No private key in",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "identity")
+			if err := ioutil.WriteFile(file, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			keys, err := ReadIdentityFile(file)
+			if test.message != "" {
+				if err == nil || !strings.Contains(err.Error(), test.message) {
+					t.Errorf("expected an error containing '%s', got '%v'", test.message, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(keys, test.keys) {
+				t.Errorf("expected the keys %v, got %v", test.keys, keys)
+			}
+		})
+	}
+}
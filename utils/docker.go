@@ -3,6 +3,7 @@ package utils
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
 	"webup/pliz/domain"
 )
@@ -13,10 +14,10 @@ type containerParsedConfig struct {
 }
 
 func GetContainerID(container string, ctx domain.ExecutionContext) (string, error) {
-	cmd := domain.NewComposeCommand([]string{"ps", "-q", container}, ctx.IsProd())
+	cmd := domain.NewComposeCommand([]string{"ps", "-q", container}, ctx)
 	containerID, err := cmd.GetResult()
 	if err != nil {
-		fmt.Println("Unable to get the 'db' container id")
+		fmt.Fprintln(os.Stderr, "Unable to get the 'db' container id")
 	}
 
 	return containerID, err
@@ -26,7 +27,7 @@ func GetContainerConfig(containerID string, ctx domain.ExecutionContext) (domain
 	cmd := domain.NewCommand([]string{"docker", "inspect", "--format", "{{json .Config}}", containerID}, true)
 	configJson, err := cmd.GetResult()
 	if err != nil {
-		fmt.Println("Unable to get the config of the 'db' container")
+		fmt.Fprintln(os.Stderr, "Unable to get the config of the 'db' container")
 	}
 
 	// parse the json
@@ -50,7 +51,7 @@ func GetExposedPorts(containerID string, ctx domain.ExecutionContext) []string {
 	cmd := domain.NewCommand([]string{"docker", "inspect", "--format", "{{json .NetworkSettings.Ports}}", containerID}, true)
 	configJson, err := cmd.GetResult()
 	if err != nil {
-		fmt.Println("Unable to get the network settings of the container")
+		fmt.Fprintln(os.Stderr, "Unable to get the network settings of the container")
 	}
 
 	// parse the json
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// PrefixWriter writes each line to out, prefixed by prefix.
+// The writers sharing the same mutex never interleave their lines.
+type PrefixWriter struct {
+	out    io.Writer
+	prefix []byte
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func NewPrefixWriter(out io.Writer, prefix string, mu *sync.Mutex) *PrefixWriter {
+	return &PrefixWriter{out: out, prefix: []byte(prefix), mu: mu}
+}
+
+func (w *PrefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	// only write the complete lines, the rest is kept until the next write
+	end := bytes.LastIndexByte(w.buf, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+
+	lines := w.buf[:end+1]
+	if err := w.writeLines(lines); err != nil {
+		return 0, err
+	}
+	w.buf = append([]byte{}, w.buf[end+1:]...)
+
+	return len(p), nil
+}
+
+// Flush writes the last line, even if it is not terminated by a newline
+func (w *PrefixWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	err := w.writeLines(append(w.buf, '\n'))
+	w.buf = nil
+
+	return err
+}
+
+func (w *PrefixWriter) writeLines(lines []byte) error {
+	prefixed := []byte{}
+	for _, line := range bytes.SplitAfter(lines, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		prefixed = append(prefixed, w.prefix...)
+		prefixed = append(prefixed, line...)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err := w.out.Write(prefixed)
+	return err
+}